@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Braendie/Telegram-bot/internal/app/export"
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+)
+
+// exportUsage describes the export subcommand
+const exportUsage = "usage: telegrambot export [-format json|csv|md|html] [-o file] <user ID>"
+
+// runExport executes the export subcommand: it writes every page of the user to the file,
+// or to the standard output if none is given
+func runExport(s storage.Storage, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	formatName := flags.String("format", export.Formats[0], "format of the export: "+strings.Join(export.Formats, ", "))
+	output := flags.String("o", "", "file to write the export to instead of the standard output")
+
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errors.New(exportUsage)
+	}
+
+	userID, err := strconv.Atoi(flags.Arg(0))
+	if err != nil {
+		return errors.New(exportUsage)
+	}
+
+	format, err := export.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
+	pages, err := s.Pages(userID)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return export.Write(os.Stdout, format, pages)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+
+	if err := export.Write(f, format, pages); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	log.Printf("exported %d pages to %s", len(pages), *output)
+
+	return nil
+}
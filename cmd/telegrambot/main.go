@@ -1,15 +1,20 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"flag"
 	"log"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/Braendie/Telegram-bot/config"
+	cfg "github.com/Braendie/Telegram-bot/config"
 	tgClient "github.com/Braendie/Telegram-bot/internal/app/clients/telegram"
+	"github.com/Braendie/Telegram-bot/internal/app/consumer"
 	event_consumer "github.com/Braendie/Telegram-bot/internal/app/consumer/event-consumer"
+	webhook_consumer "github.com/Braendie/Telegram-bot/internal/app/consumer/webhook-consumer"
+	"github.com/Braendie/Telegram-bot/internal/app/enricher"
 	"github.com/Braendie/Telegram-bot/internal/app/events/telegram"
-	"github.com/Braendie/Telegram-bot/internal/app/storage/sqlstorage"
 	"github.com/BurntSushi/toml"
 )
 
@@ -27,43 +32,97 @@ func main() {
 	flag.Parse()
 
 	// Load configuration from the TOML file
-	config := config.NewConfig()
+	config := cfg.NewConfig()
 	_, err := toml.DecodeFile(configPath, config)
 	if err != nil {
 		log.Fatal("can't decode toml file", err)
 	}
 
-	tgClient := tgClient.New(config.TGBotHost, config.TGToken)
+	tgClient := tgClient.New(config.TGBotHost, config.TGToken, time.Duration(config.PollTimeout)*time.Second)
 
-	// Initialize a connection to the database
-	db, err := newDB(config.DatabaseURL)
+	// "telegrambot migrate up|down|status" manages the SQL schema and exits
+	if flag.Arg(0) == "migrate" {
+		db, dialect, err := openDB(config)
+		if err != nil {
+			log.Fatal("can't create database ", err)
+		}
+
+		err = runMigrate(db, dialect, flag.Args()[1:])
+		_ = db.Close()
+		if err != nil {
+			log.Fatal("can't migrate database ", err)
+		}
+
+		return
+	}
+
+	storage, closeStorage, err := newStorage(config)
 	if err != nil {
-		log.Fatal("can't create database", err)
+		log.Fatal("can't create storage ", err)
+	}
+
+	// "telegrambot export [-format f] [-o file] <user ID>" writes the pages of a user and exits
+	if flag.Arg(0) == "export" {
+		err = runExport(storage, flag.Args()[1:])
+		if closeErr := closeStorage(); closeErr != nil {
+			log.Print("can't close storage", closeErr)
+		}
+		if err != nil {
+			log.Fatal("can't export pages ", err)
+		}
+
+		return
+	}
+
+	// Saved pages are fetched in the background to store their title and description
+	var pageEnricher *enricher.Enricher
+	if config.Enrich {
+		timeout := time.Duration(config.EnrichTimeout) * time.Second
+		fetcher := enricher.NewFetcher(timeout, int64(config.EnrichMaxSize), config.EnrichAllowPrivate)
+		pageEnricher = enricher.New(fetcher, storage, config.EnrichWorkers, config.EnrichQueueSize)
 	}
 
-	defer db.Close()
+	eventsProcessor := telegram.New(tgClient, storage, storage, pageEnricher)
 
-	storage := sqlstorage.New(db)
-	eventsProcessor := telegram.New(tgClient, storage)
+	shutdownTimeout := time.Duration(config.ShutdownTimeout) * time.Second
 
-	log.Print("service started")
+	var consumer consumer.Consumer
 
-	consumer := event_consumer.New(eventsProcessor, eventsProcessor, config.BatchSize)
-	if err := consumer.Start(); err != nil {
-		log.Fatal("service is stopped", err)
+	// Pick how updates are delivered: pulled with getUpdates or pushed to our webhook
+	switch config.Mode {
+	case cfg.ModePolling:
+		consumer = event_consumer.New(eventsProcessor, eventsProcessor, config.BatchSize, config.Workers, config.QueueSize, shutdownTimeout)
+	case cfg.ModeWebhook:
+		consumer = webhook_consumer.New(tgClient, eventsProcessor, eventsProcessor, config.WebhookAddr, config.WebhookURL, config.WebhookSecret, shutdownTimeout)
+	default:
+		log.Fatalf("unknown mode %q", config.Mode)
 	}
-}
 
-// newDB creates a new PostgreSQL database connection and checks its availability
-func newDB(databaseURL string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", databaseURL)
-	if err != nil {
-		return nil, err
+	// Cancel the consumer on SIGINT/SIGTERM so it can finish in-flight events and exit cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("service started in %s mode", config.Mode)
+
+	startErr := consumer.Start(ctx)
+
+	// Imports stop after the batch being saved, the requests still running at the deadline are cancelled
+	closeCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	eventsProcessor.Close(closeCtx)
+	cancel()
+
+	// Pages still waiting to be fetched are dropped rather than holding up the shutdown
+	closeCtx, cancel = context.WithTimeout(context.Background(), shutdownTimeout)
+	pageEnricher.Close(closeCtx)
+	cancel()
+
+	if err := closeStorage(); err != nil {
+		log.Print("can't close storage", err)
 	}
 
-	if err := db.Ping(); err != nil {
-		return nil, err
+	if startErr != nil {
+		log.Fatal("service is stopped", startErr)
 	}
 
-	return db, nil
+	log.Print("service stopped")
 }
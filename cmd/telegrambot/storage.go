@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	cfg "github.com/Braendie/Telegram-bot/config"
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/files"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/migrations"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/sqlitestorage"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/sqlstorage"
+)
+
+// backend is a storage implementation that keeps both the pages and the update offset
+type backend interface {
+	storage.Storage
+	storage.OffsetStorage
+}
+
+// newStorage creates the storage selected by storage_type and returns it along with a
+// function releasing its resources
+func newStorage(config *cfg.Config) (backend, func() error, error) {
+	if config.StorageType == cfg.StorageFiles {
+		return files.New(config.StoragePath), func() error { return nil }, nil
+	}
+
+	db, dialect, err := openDB(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if config.AutoMigrate {
+		if err := runMigrate(db, dialect, []string{"up"}); err != nil {
+			_ = db.Close()
+			return nil, nil, fmt.Errorf("can't migrate database: %w", err)
+		}
+	}
+
+	if dialect == migrations.SQLite {
+		return sqlitestorage.New(db), db.Close, nil
+	}
+
+	return sqlstorage.New(db), db.Close, nil
+}
+
+// openDB opens the SQL database selected by storage_type and returns it with its migration dialect
+func openDB(config *cfg.Config) (*sql.DB, string, error) {
+	switch config.StorageType {
+	case cfg.StoragePostgres:
+		// Initialize a connection to the database
+		db, err := newDB(config.DatabaseURL)
+		if err != nil {
+			return nil, "", fmt.Errorf("can't create database: %w", err)
+		}
+
+		return db, migrations.Postgres, nil
+	case cfg.StorageSQLite:
+		db, err := sqlitestorage.Open(config.SQLitePath)
+		if err != nil {
+			return nil, "", fmt.Errorf("can't create database: %w", err)
+		}
+
+		return db, migrations.SQLite, nil
+	default:
+		return nil, "", fmt.Errorf("unknown SQL storage type %q", config.StorageType)
+	}
+}
+
+// newDB creates a new PostgreSQL database connection and checks its availability
+func newDB(databaseURL string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
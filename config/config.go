@@ -1,17 +1,65 @@
 package config
 
+// Delivery modes supported by the bot
+const (
+	ModePolling = "polling"
+	ModeWebhook = "webhook"
+)
+
+// Storage backends supported by the bot
+const (
+	StoragePostgres = "postgres"
+	StorageSQLite   = "sqlite"
+	StorageFiles    = "files"
+)
+
 // Config defines the structure of the application's configuration settings.
 type Config struct {
-	TGBotHost   string `toml:"tg_bot_host"`
-	StoragePath string `toml:"storage_path"`
-	BatchSize   int    `toml:"batch_size"`
-	DatabaseURL string `toml:"database_url"`
-	TGToken     string `toml:"telegram_token"`
+	TGBotHost       string `toml:"tg_bot_host"`
+	StorageType     string `toml:"storage_type"`
+	StoragePath     string `toml:"storage_path"`
+	BatchSize       int    `toml:"batch_size"`
+	Workers         int    `toml:"workers"`
+	QueueSize       int    `toml:"queue_size"`
+	PollTimeout     int    `toml:"poll_timeout"`
+	ShutdownTimeout int    `toml:"shutdown_timeout"`
+	DatabaseURL     string `toml:"database_url"`
+	SQLitePath      string `toml:"sqlite_path"`
+	AutoMigrate     bool   `toml:"auto_migrate"`
+	TGToken         string `toml:"telegram_token"`
+	Mode            string `toml:"mode"`
+	WebhookURL      string `toml:"webhook_url"`
+	WebhookAddr     string `toml:"webhook_addr"`
+	WebhookSecret   string `toml:"webhook_secret"`
+
+	// Fetching the metadata of saved pages
+	Enrich             bool `toml:"enrich"`
+	EnrichTimeout      int  `toml:"enrich_timeout"`
+	EnrichMaxSize      int  `toml:"enrich_max_size"`
+	EnrichWorkers      int  `toml:"enrich_workers"`
+	EnrichQueueSize    int  `toml:"enrich_queue_size"`
+	EnrichAllowPrivate bool `toml:"enrich_allow_private"`
 }
 
 // NewConfig returns a default configuration with pre-defined values.
 func NewConfig() *Config {
 	return &Config{
-		TGBotHost: "api.telegram.org",
+		TGBotHost:       "api.telegram.org",
+		StorageType:     StoragePostgres,
+		StoragePath:     "storage",
+		SQLitePath:      "telegram-bot.db",
+		BatchSize:       100,
+		Workers:         4,
+		QueueSize:       100,
+		PollTimeout:     30,
+		ShutdownTimeout: 10,
+		Mode:            ModePolling,
+		WebhookAddr:     ":8080",
+		AutoMigrate:     true,
+		Enrich:          true,
+		EnrichTimeout:   10,
+		EnrichMaxSize:   1 << 20,
+		EnrichWorkers:   2,
+		EnrichQueueSize: 100,
 	}
 }
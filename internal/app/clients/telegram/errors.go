@@ -0,0 +1,52 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBadRequest      = errors.New("bad request")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrForbidden       = errors.New("forbidden")
+	ErrChatNotFound    = errors.New("chat not found")
+	ErrTooManyRequests = errors.New("too many requests")
+	ErrServer          = errors.New("telegram server error")
+	ErrFileTooLarge    = errors.New("file is too large")
+)
+
+// APIError describes a failed Bot API call as reported by Telegram in the error_code,
+// description and parameters fields of the response
+type APIError struct {
+	Code        int
+	Description string
+	RetryAfter  time.Duration
+}
+
+// Error returns the Telegram error code and description
+func (err *APIError) Error() string {
+	return fmt.Sprintf("telegram api error %d: %s", err.Code, err.Description)
+}
+
+// Unwrap maps the error to one of the package sentinel errors, so callers can use errors.Is
+func (err *APIError) Unwrap() error {
+	switch {
+	case err.Code == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case err.Code == http.StatusTooManyRequests:
+		return ErrTooManyRequests
+	case strings.Contains(strings.ToLower(err.Description), "chat not found"):
+		return ErrChatNotFound
+	case err.Code == http.StatusForbidden:
+		return ErrForbidden
+	case err.Code == http.StatusBadRequest:
+		return ErrBadRequest
+	case err.Code >= http.StatusInternalServerError:
+		return ErrServer
+	}
+
+	return nil
+}
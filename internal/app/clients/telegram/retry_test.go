@@ -0,0 +1,41 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		attempt   int
+		wantDelay time.Duration
+		wantRetry bool
+	}{
+		{"server error", &APIError{Code: http.StatusInternalServerError}, 0, time.Second, true},
+		{"server error backs off", &APIError{Code: http.StatusBadGateway}, 1, 2 * time.Second, true},
+		{"server error backs off further", &APIError{Code: http.StatusServiceUnavailable}, 2, 4 * time.Second, true},
+		{"server error out of retries", &APIError{Code: http.StatusInternalServerError}, maxRetries, 0, false},
+		{"flood wait", &APIError{Code: http.StatusTooManyRequests, RetryAfter: 5 * time.Second}, 0, 5 * time.Second, true},
+		{"flood wait without retry_after", &APIError{Code: http.StatusTooManyRequests}, 0, minBackoff, true},
+		{"flood wait at the cap", &APIError{Code: http.StatusTooManyRequests, RetryAfter: maxRetryAfter}, 0, maxRetryAfter, true},
+		{"flood wait over the cap", &APIError{Code: http.StatusTooManyRequests, RetryAfter: maxRetryAfter + time.Second}, 0, 0, false},
+		{"wrapped", fmt.Errorf("can't send message: %w", &APIError{Code: http.StatusInternalServerError}), 0, time.Second, true},
+		{"bad request", &APIError{Code: http.StatusBadRequest}, 0, 0, false},
+		{"forbidden", &APIError{Code: http.StatusForbidden}, 0, 0, false},
+		{"network error", errors.New("connection refused"), 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, retry := retryDelay(tt.err, tt.attempt)
+			if delay != tt.wantDelay || retry != tt.wantRetry {
+				t.Errorf("got %s, %t, want %s, %t", delay, retry, tt.wantDelay, tt.wantRetry)
+			}
+		})
+	}
+}
@@ -1,29 +1,62 @@
 package telegram
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"log"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
 )
 
+const (
+	// requestTimeout is the time allowed for a request on top of the long polling timeout
+	requestTimeout = 10 * time.Second
+
+	maxRetries    = 3
+	minBackoff    = time.Second
+	maxRetryAfter = time.Minute
+
+	// defaultScheme is used when the host is given without one, as the public Bot API is served over HTTPS
+	defaultScheme = "https"
+)
+
 // Client represents a Telegram client that communicates with the Telegram Bot API
 type Client struct {
-	host     string
-	basePath string
-	client   http.Client
+	scheme      string
+	host        string
+	basePath    string
+	pollTimeout time.Duration
+	client      http.Client
 }
 
-// New initializes and returns a new Client with the provided host and token
-func New(host, token string) *Client {
+// New initializes and returns a new Client with the provided host and token. The host may
+// carry a scheme, such as "http://localhost:8081" for a local Bot API server or a test
+// server, otherwise HTTPS is used. pollTimeout is how long getUpdates may wait for new
+// updates before returning an empty result
+func New(host, token string, pollTimeout time.Duration) *Client {
+	scheme := defaultScheme
+	if s, h, ok := strings.Cut(host, "://"); ok {
+		scheme, host = s, h
+	}
+
 	return &Client{
-		host:     host,
-		basePath: newBasePath(token),
-		client:   http.Client{},
+		scheme:      scheme,
+		host:        host,
+		basePath:    newBasePath(token),
+		pollTimeout: pollTimeout,
+		client: http.Client{
+			Timeout: pollTimeout + requestTimeout,
+		},
 	}
 }
 
@@ -32,24 +65,41 @@ func newBasePath(token string) string {
 	return "bot" + token
 }
 
-// Updates retrieves new updates (messages, commands, etc.) from the Telegram API with the specified offset and limit
-func (c *Client) Updates(offset, limit int) ([]Update, error) {
+// Updates retrieves new updates (messages, commands, etc.) from the Telegram API with the specified offset and limit.
+// It long polls: the call blocks until an update arrives, the poll timeout passes or ctx is cancelled
+func (c *Client) Updates(ctx context.Context, offset, limit int) ([]Update, error) {
 	q := url.Values{}
 	q.Add("offset", strconv.Itoa(offset))
 	q.Add("limit", strconv.Itoa(limit))
+	q.Add("timeout", strconv.Itoa(int(c.pollTimeout.Seconds())))
 
-	data, err := c.doRequest("getUpdates", q)
+	data, err := c.doRequest(ctx, "getUpdates", q)
 	if err != nil {
 		return nil, e.Wrap("can't get updates", err)
 	}
 
-	var res UpdatesResponse
+	var res []Update
 
 	if err := json.Unmarshal(data, &res); err != nil {
 		return nil, e.Wrap("can't get updates", err)
 	}
 
-	return res.Result, nil
+	return res, nil
+}
+
+// ConfirmUpdates acknowledges every update with an ID lower than offset, so Telegram
+// does not deliver them again. It does not wait for new updates
+func (c *Client) ConfirmUpdates(ctx context.Context, offset int) error {
+	q := url.Values{}
+	q.Add("offset", strconv.Itoa(offset))
+	q.Add("limit", "1")
+	q.Add("timeout", "0")
+
+	if _, err := c.doRequest(ctx, "getUpdates", q); err != nil {
+		return e.Wrap("can't confirm updates", err)
+	}
+
+	return nil
 }
 
 // SendMessage sends a text message to a specified chat ID
@@ -58,29 +108,290 @@ func (c *Client) SendMessage(chatID int, text string) error {
 	q.Add("chat_id", strconv.Itoa(chatID))
 	q.Add("text", text)
 
-	_, err := c.doRequest("sendMessage", q)
+	_, err := c.doRequest(context.Background(), "sendMessage", q)
+	if err != nil {
+		return e.Wrap("can't send message", err)
+	}
+
+	return nil
+}
+
+// SendMessageID sends a text message to a specified chat ID like SendMessage and returns
+// the ID of the sent message, so it can be edited later. The request is cancelled with ctx
+func (c *Client) SendMessageID(ctx context.Context, chatID int, text string) (int, error) {
+	q := url.Values{}
+	q.Add("chat_id", strconv.Itoa(chatID))
+	q.Add("text", text)
+
+	data, err := c.doRequest(ctx, "sendMessage", q)
+	if err != nil {
+		return 0, e.Wrap("can't send message", err)
+	}
+
+	var msg IncomingMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return 0, e.Wrap("can't send message", err)
+	}
+
+	return msg.ID, nil
+}
+
+// SendMessageWithKeyboard sends a text message to a specified chat ID with an inline keyboard attached
+func (c *Client) SendMessageWithKeyboard(chatID int, text string, keyboard InlineKeyboardMarkup) error {
+	markup, err := json.Marshal(keyboard)
 	if err != nil {
 		return e.Wrap("can't send message", err)
 	}
 
+	q := url.Values{}
+	q.Add("chat_id", strconv.Itoa(chatID))
+	q.Add("text", text)
+	q.Add("reply_markup", string(markup))
+
+	if _, err := c.doRequest(context.Background(), "sendMessage", q); err != nil {
+		return e.Wrap("can't send message", err)
+	}
+
 	return nil
 }
 
-// doRequest sends an HTTP GET request to the specified Telegram API method with query parameters
-// It returns the response body as a byte slice
-func (c *Client) doRequest(method string, query url.Values) ([]byte, error) {
+// EditMessageText replaces the text of a sent message along with its inline keyboard, a nil
+// keyboard removes it. The request is cancelled with ctx
+func (c *Client) EditMessageText(ctx context.Context, chatID, messageID int, text string, keyboard *InlineKeyboardMarkup) error {
+	q := url.Values{}
+	q.Add("chat_id", strconv.Itoa(chatID))
+	q.Add("message_id", strconv.Itoa(messageID))
+	q.Add("text", text)
+
+	if keyboard != nil {
+		markup, err := json.Marshal(keyboard)
+		if err != nil {
+			return e.Wrap("can't edit message text", err)
+		}
+
+		q.Add("reply_markup", string(markup))
+	}
+
+	if _, err := c.doRequest(ctx, "editMessageText", q); err != nil {
+		return e.Wrap("can't edit message text", err)
+	}
+
+	return nil
+}
+
+// EditMessageReplyMarkup replaces the inline keyboard of a sent message, a nil keyboard removes it
+func (c *Client) EditMessageReplyMarkup(chatID, messageID int, keyboard *InlineKeyboardMarkup) error {
+	q := url.Values{}
+	q.Add("chat_id", strconv.Itoa(chatID))
+	q.Add("message_id", strconv.Itoa(messageID))
+
+	if keyboard != nil {
+		markup, err := json.Marshal(keyboard)
+		if err != nil {
+			return e.Wrap("can't edit message markup", err)
+		}
+
+		q.Add("reply_markup", string(markup))
+	}
+
+	if _, err := c.doRequest(context.Background(), "editMessageReplyMarkup", q); err != nil {
+		return e.Wrap("can't edit message markup", err)
+	}
+
+	return nil
+}
+
+// AnswerCallbackQuery acknowledges a button press, optionally showing a short notification to the user
+func (c *Client) AnswerCallbackQuery(callbackID, text string) error {
+	q := url.Values{}
+	q.Add("callback_query_id", callbackID)
+	if text != "" {
+		q.Add("text", text)
+	}
+
+	if _, err := c.doRequest(context.Background(), "answerCallbackQuery", q); err != nil {
+		return e.Wrap("can't answer callback query", err)
+	}
+
+	return nil
+}
+
+// SendDocument uploads a file to a specified chat ID, with the caption shown under it if there is one
+func (c *Client) SendDocument(chatID int, fileName string, data []byte, caption string) error {
+	q := url.Values{}
+	q.Add("chat_id", strconv.Itoa(chatID))
+	if caption != "" {
+		q.Add("caption", caption)
+	}
+
+	file := &inputFile{field: "document", name: fileName, data: data}
+
+	if _, err := c.doUpload(context.Background(), "sendDocument", q, file); err != nil {
+		return e.Wrap("can't send document", err)
+	}
+
+	return nil
+}
+
+// GetFile prepares a file sent to the bot for download and returns its path
+func (c *Client) GetFile(ctx context.Context, fileID string) (File, error) {
+	q := url.Values{}
+	q.Add("file_id", fileID)
+
+	data, err := c.doRequest(ctx, "getFile", q)
+	if err != nil {
+		return File{}, e.Wrap("can't get file", err)
+	}
+
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		return File{}, e.Wrap("can't get file", err)
+	}
+
+	return file, nil
+}
+
+// DownloadFile downloads a file sent to the bot. Files larger than maxSize bytes are not
+// downloaded and ErrFileTooLarge is returned. The download is cancelled with ctx
+func (c *Client) DownloadFile(ctx context.Context, fileID string, maxSize int64) ([]byte, error) {
+	file, err := c.GetFile(ctx, fileID)
+	if err != nil {
+		return nil, e.Wrap("can't download file", err)
+	}
+
+	if file.FileSize > maxSize {
+		return nil, e.Wrap("can't download file", ErrFileTooLarge)
+	}
+
 	u := url.URL{
-		Scheme: "https",
+		Scheme: c.scheme,
 		Host:   c.host,
-		Path:   path.Join(c.basePath, method),
+		Path:   path.Join("file", c.basePath, file.FilePath),
 	}
 
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
-		return nil, e.Wrap("can't do request", err)
+		return nil, e.Wrap("can't download file", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, e.Wrap("can't download file", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, e.Wrap("can't download file", &APIError{Code: resp.StatusCode, Description: http.StatusText(resp.StatusCode)})
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, e.Wrap("can't download file", err)
+	}
+
+	if int64(len(data)) > maxSize {
+		return nil, e.Wrap("can't download file", ErrFileTooLarge)
+	}
+
+	return data, nil
+}
+
+// SetWebhook registers the URL Telegram should deliver updates to, along with the secret
+// token it will send back in the X-Telegram-Bot-Api-Secret-Token header
+func (c *Client) SetWebhook(webhookURL, secret string) error {
+	q := url.Values{}
+	q.Add("url", webhookURL)
+	if secret != "" {
+		q.Add("secret_token", secret)
+	}
+
+	_, err := c.doRequest(context.Background(), "setWebhook", q)
+	if err != nil {
+		return e.Wrap("can't set webhook", err)
+	}
+
+	return nil
+}
+
+// DeleteWebhook removes the webhook integration so updates can be fetched with getUpdates again
+func (c *Client) DeleteWebhook() error {
+	_, err := c.doRequest(context.Background(), "deleteWebhook", url.Values{})
+	if err != nil {
+		return e.Wrap("can't delete webhook", err)
+	}
+
+	return nil
+}
+
+// inputFile is a file uploaded along with the parameters of a request
+type inputFile struct {
+	field string
+	name  string
+	data  []byte
+}
+
+// doRequest calls the specified Telegram API method with query parameters and returns the
+// result payload. Flood-control and server errors are retried with back-off before giving up
+func (c *Client) doRequest(ctx context.Context, method string, query url.Values) (json.RawMessage, error) {
+	return c.doUpload(ctx, method, query, nil)
+}
+
+// doUpload works like doRequest, sending the file along with the parameters if it is set
+func (c *Client) doUpload(ctx context.Context, method string, query url.Values, file *inputFile) (json.RawMessage, error) {
+	for attempt := 0; ; attempt++ {
+		data, err := c.request(ctx, method, query, file)
+		if err == nil {
+			return data, nil
+		}
+
+		delay, ok := retryDelay(err, attempt)
+		if !ok {
+			return nil, err
+		}
+
+		log.Printf("[WARN] telegram: %s failed, retrying in %s: %s", method, delay, err.Error())
+
+		select {
+		case <-ctx.Done():
+			return nil, e.Wrap("can't do request", ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// request sends a single HTTP request to the Telegram API and decodes the response envelope.
+// The parameters are sent in the query of a GET request, or as a multipart form along with
+// the file in a POST request when there is one
+func (c *Client) request(ctx context.Context, method string, query url.Values, file *inputFile) (json.RawMessage, error) {
+	u := url.URL{
+		Scheme: c.scheme,
+		Host:   c.host,
+		Path:   path.Join(c.basePath, method),
 	}
 
-	req.URL.RawQuery = query.Encode()
+	var req *http.Request
+	var err error
+
+	if file == nil {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, e.Wrap("can't do request", err)
+		}
+
+		req.URL.RawQuery = query.Encode()
+	} else {
+		body, contentType, err := multipartBody(query, file)
+		if err != nil {
+			return nil, e.Wrap("can't do request", err)
+		}
+
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, u.String(), body)
+		if err != nil {
+			return nil, e.Wrap("can't do request", err)
+		}
+
+		req.Header.Set("Content-Type", contentType)
+	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -94,5 +405,74 @@ func (c *Client) doRequest(method string, query url.Values) ([]byte, error) {
 		return nil, e.Wrap("can't do request", err)
 	}
 
-	return body, nil
+	var res Response
+	if err := json.Unmarshal(body, &res); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, &APIError{Code: resp.StatusCode, Description: http.StatusText(resp.StatusCode)}
+		}
+
+		return nil, e.Wrap("can't do request", err)
+	}
+
+	if !res.Ok {
+		apiErr := &APIError{Code: res.ErrorCode, Description: res.Description}
+		if res.Parameters != nil {
+			apiErr.RetryAfter = time.Duration(res.Parameters.RetryAfter) * time.Second
+		}
+
+		return nil, apiErr
+	}
+
+	return res.Result, nil
+}
+
+// multipartBody encodes the parameters and the file as multipart form data and returns it
+// with its content type
+func multipartBody(query url.Values, file *inputFile) (*bytes.Buffer, string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	for key, values := range query {
+		for _, value := range values {
+			if err := w.WriteField(key, value); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	part, err := w.CreateFormFile(file.field, file.name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, err := part.Write(file.data); err != nil {
+		return nil, "", err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return &body, w.FormDataContentType(), nil
+}
+
+// retryDelay reports whether a failed request is worth retrying and how long to wait before it
+func retryDelay(err error, attempt int) (time.Duration, bool) {
+	var apiErr *APIError
+	if attempt >= maxRetries || !errors.As(err, &apiErr) {
+		return 0, false
+	}
+
+	switch {
+	case errors.Is(apiErr, ErrTooManyRequests):
+		if apiErr.RetryAfter > maxRetryAfter {
+			return 0, false
+		}
+
+		return max(apiErr.RetryAfter, minBackoff), true
+	case errors.Is(apiErr, ErrServer):
+		return minBackoff << attempt, true
+	}
+
+	return 0, false
 }
@@ -0,0 +1,72 @@
+package telegram_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Braendie/Telegram-bot/internal/app/clients/telegram"
+	"github.com/Braendie/Telegram-bot/internal/app/clients/telegram/telegramtest"
+)
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		code        int
+		description string
+		want        error
+		notWant     error
+	}{
+		{"bad request", http.StatusBadRequest, "Bad Request: message text is empty", telegram.ErrBadRequest, telegram.ErrChatNotFound},
+		{"chat not found", http.StatusBadRequest, "Bad Request: chat not found", telegram.ErrChatNotFound, telegram.ErrBadRequest},
+		{"chat not found in a group", http.StatusForbidden, "Forbidden: chat not found", telegram.ErrChatNotFound, telegram.ErrForbidden},
+		{"unauthorized", http.StatusUnauthorized, "Unauthorized", telegram.ErrUnauthorized, telegram.ErrForbidden},
+		{"forbidden", http.StatusForbidden, "Forbidden: bot was blocked by the user", telegram.ErrForbidden, telegram.ErrChatNotFound},
+		// A flood wait longer than a minute isn't waited out
+		{"flood wait over the cap", http.StatusTooManyRequests, "", telegram.ErrTooManyRequests, telegram.ErrServer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := telegramtest.NewServer(t)
+
+			if tt.code == http.StatusTooManyRequests {
+				api.FloodWait("sendMessage", 120)
+			} else {
+				api.Fail("sendMessage", tt.code, tt.description)
+			}
+
+			err := api.Client().SendMessage(1, "hi")
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+
+			var apiErr *telegram.APIError
+			if !errors.As(err, &apiErr) || apiErr.Code != tt.code {
+				t.Errorf("got error %v, want an APIError with code %d", err, tt.code)
+			}
+
+			if errors.Is(err, tt.notWant) {
+				t.Errorf("got error %v, want it not to be %v", err, tt.notWant)
+			}
+
+			if calls := api.Calls("sendMessage"); len(calls) != 1 {
+				t.Errorf("got %d calls, want the error returned without a retry", len(calls))
+			}
+		})
+	}
+}
+
+func TestServerErrorIsRetried(t *testing.T) {
+	api := telegramtest.NewServer(t)
+	api.Fail("sendMessage", http.StatusBadGateway, "Bad Gateway")
+
+	if err := api.Client().SendMessage(1, "hi"); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	calls := api.Calls("sendMessage")
+	if len(calls) != 2 || calls[0].Failure == nil || calls[1].Failure != nil {
+		t.Errorf("got calls %+v, want a failed one and a retry", calls)
+	}
+}
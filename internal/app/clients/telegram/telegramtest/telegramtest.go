@@ -0,0 +1,504 @@
+// Package telegramtest provides a fake Telegram Bot API server for tests. It queues updates
+// for getUpdates, records the calls the bot makes and can be told to fail them
+package telegramtest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Braendie/Telegram-bot/internal/app/clients/telegram"
+)
+
+// Token is the bot token the fake server accepts, requests with any other token are unauthorized
+const Token = "123456:test-token"
+
+// maxUploadMemory is the size of uploaded files kept in memory while parsing, larger ones go to disk
+const maxUploadMemory = 1 << 20
+
+// Server is a fake Bot API backed by httptest.Server
+type Server struct {
+	srv *httptest.Server
+
+	mu            sync.Mutex
+	updates       []telegram.Update
+	nextUpdateID  int
+	nextMessageID int
+	calls         []Call
+	sent          []Message
+	documents     []Document
+	files         map[string][]byte
+	answers       []string
+	failures      map[string][]Failure
+	held          map[string]chan struct{}
+}
+
+// Call is a single Bot API request received by the server. Failure is set when the
+// server replied with an error added by Fail or FloodWait
+type Call struct {
+	Method  string
+	Params  url.Values
+	Failure *Failure
+}
+
+// Failure is an error response the server replies with instead of handling a call
+type Failure struct {
+	Code        int
+	Description string
+	// RetryAfter is the number of seconds sent in the parameters of a 429 response
+	RetryAfter int
+}
+
+// Message is a message the bot sent with sendMessage
+type Message struct {
+	ID          int
+	ChatID      int
+	Text        string
+	ReplyMarkup *telegram.InlineKeyboardMarkup
+}
+
+// Document is a file the bot sent with sendDocument
+type Document struct {
+	ChatID   int
+	FileName string
+	Data     []byte
+	Caption  string
+}
+
+// NewServer starts a fake Bot API server that is closed when the test finishes
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		nextUpdateID:  1,
+		nextMessageID: 1,
+		failures:      make(map[string][]Failure),
+		files:         make(map[string][]byte),
+		held:          make(map[string]chan struct{}),
+	}
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.srv.Close)
+
+	return s
+}
+
+// URL returns the base URL of the server, to be passed to telegram.New as the host
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Client returns a client talking to the server that doesn't wait in getUpdates
+func (s *Server) Client() *telegram.Client {
+	return telegram.New(s.URL(), Token, 0)
+}
+
+// AddMessage queues a text message sent by a user in a private chat and returns the update
+func (s *Server) AddMessage(userID int, userName, text string) telegram.Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addUpdate(telegram.Update{
+		Message: s.message(userID, userName, text),
+	})
+}
+
+// AddIncoming queues a message sent by a user in a private chat and returns the update. The
+// ID, sender and chat of msg are filled in, the rest, such as a caption, entities or the
+// forward origin, is kept as is
+func (s *Server) AddIncoming(userID int, userName string, msg telegram.IncomingMessage) telegram.Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.message(userID, userName, msg.Text)
+	msg.ID, msg.From, msg.Chat = m.ID, m.From, m.Chat
+
+	return s.addUpdate(telegram.Update{
+		Message: &msg,
+	})
+}
+
+// AddDocument queues a file sent by a user in a private chat and returns the update. The
+// file can be downloaded through getFile
+func (s *Server) AddDocument(userID int, userName, fileName string, data []byte) telegram.Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg := s.message(userID, userName, "")
+	fileID := "file" + strconv.Itoa(msg.ID)
+
+	s.files[fileID] = data
+	msg.Document = &telegram.Document{
+		FileID:   fileID,
+		FileName: fileName,
+		FileSize: int64(len(data)),
+	}
+
+	return s.addUpdate(telegram.Update{
+		Message: msg,
+	})
+}
+
+// AddCallback queues a press of a button with the given callback data under a message the
+// bot sent to the user and returns the update
+func (s *Server) AddCallback(userID int, userName string, message Message, data string) telegram.Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addUpdate(telegram.Update{
+		CallbackQuery: &telegram.CallbackQuery{
+			ID:   strconv.Itoa(s.nextUpdateID),
+			From: telegram.From{ID: userID, UserName: userName},
+			Message: &telegram.IncomingMessage{
+				ID:   message.ID,
+				Text: message.Text,
+				Chat: telegram.Chat{ID: message.ChatID},
+			},
+			Data: data,
+		},
+	})
+}
+
+// Fail makes the next call of method fail with the given error code and description.
+// Several failures of the same method are returned in the order they were added
+func (s *Server) Fail(method string, code int, description string) {
+	s.addFailure(method, Failure{Code: code, Description: description})
+}
+
+// FloodWait makes the next call of method fail with 429 Too Many Requests, asking the
+// client to retry after the given number of seconds
+func (s *Server) FloodWait(method string, retryAfter int) {
+	s.addFailure(method, Failure{
+		Code:        http.StatusTooManyRequests,
+		Description: "Too Many Requests: retry after " + strconv.Itoa(retryAfter),
+		RetryAfter:  retryAfter,
+	})
+}
+
+// Hold makes the calls of method wait until the returned function is called or the client
+// gives up on them. Held calls are recorded once they go on
+func (s *Server) Hold(method string) (release func()) {
+	held := make(chan struct{})
+
+	s.mu.Lock()
+	s.held[method] = held
+	s.mu.Unlock()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.held, method)
+			s.mu.Unlock()
+
+			close(held)
+		})
+	}
+}
+
+// Calls returns the calls of method received so far, including the failed ones
+func (s *Server) Calls(method string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res []Call
+	for _, call := range s.calls {
+		if call.Method == method {
+			res = append(res, call)
+		}
+	}
+
+	return res
+}
+
+// Sent returns the messages the bot has sent successfully, in order and as they look after
+// the edits made since
+func (s *Server) Sent() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message{}, s.sent...)
+}
+
+// Answers returns the notification texts of the answered callback queries, in order
+func (s *Server) Answers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.answers...)
+}
+
+// Documents returns the files the bot has sent successfully, in order
+func (s *Server) Documents() []Document {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Document{}, s.documents...)
+}
+
+// handle serves a single Bot API request
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if filePath, ok := strings.CutPrefix(r.URL.Path, "/file/bot"+Token+"/"); ok {
+		s.serveFile(w, filePath)
+		return
+	}
+
+	token, method, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/bot"), "/")
+	if !ok || token != Token {
+		writeError(w, Failure{Code: http.StatusUnauthorized, Description: "Unauthorized"})
+		return
+	}
+
+	s.mu.Lock()
+	held := s.held[method]
+	s.mu.Unlock()
+
+	if held != nil {
+		select {
+		case <-held:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		err = r.ParseMultipartForm(maxUploadMemory)
+	} else {
+		err = r.ParseForm()
+	}
+	if err != nil {
+		writeError(w, Failure{Code: http.StatusBadRequest, Description: "Bad Request: " + err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	call := Call{Method: method, Params: r.Form}
+
+	if failures := s.failures[method]; len(failures) > 0 {
+		s.failures[method] = failures[1:]
+		call.Failure = &failures[0]
+		s.calls = append(s.calls, call)
+
+		writeError(w, failures[0])
+		return
+	}
+
+	s.calls = append(s.calls, call)
+
+	switch method {
+	case "getUpdates":
+		writeResult(w, s.getUpdates(call.Params))
+	case "sendMessage":
+		writeResult(w, s.sendMessage(call.Params))
+	case "sendDocument":
+		msg, err := s.sendDocument(r)
+		if err != nil {
+			writeError(w, Failure{Code: http.StatusBadRequest, Description: "Bad Request: " + err.Error()})
+			return
+		}
+		writeResult(w, msg)
+	case "getFile":
+		fileID := call.Params.Get("file_id")
+		data, ok := s.files[fileID]
+		if !ok {
+			writeError(w, Failure{Code: http.StatusBadRequest, Description: "Bad Request: invalid file_id"})
+			return
+		}
+		writeResult(w, telegram.File{FileID: fileID, FileSize: int64(len(data)), FilePath: "documents/" + fileID})
+	case "answerCallbackQuery":
+		s.answers = append(s.answers, call.Params.Get("text"))
+		writeResult(w, true)
+	case "editMessageText", "editMessageReplyMarkup":
+		if !s.editMessage(method, call.Params) {
+			writeError(w, Failure{Code: http.StatusBadRequest, Description: "Bad Request: message to edit not found"})
+			return
+		}
+		writeResult(w, true)
+	case "setWebhook", "deleteWebhook":
+		writeResult(w, true)
+	default:
+		writeError(w, Failure{Code: http.StatusNotFound, Description: "Not Found"})
+	}
+}
+
+// getUpdates drops the updates confirmed by offset and returns up to limit of the rest
+func (s *Server) getUpdates(params url.Values) []telegram.Update {
+	offset, _ := strconv.Atoi(params.Get("offset"))
+	limit, err := strconv.Atoi(params.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+
+	for len(s.updates) > 0 && s.updates[0].ID < offset {
+		s.updates = s.updates[1:]
+	}
+
+	res := s.updates[:min(limit, len(s.updates))]
+
+	return append([]telegram.Update{}, res...)
+}
+
+// sendMessage records a message sent by the bot and returns it the way Telegram does
+func (s *Server) sendMessage(params url.Values) *telegram.IncomingMessage {
+	msg := Message{
+		ID:   s.nextMessageID,
+		Text: params.Get("text"),
+	}
+	s.nextMessageID++
+
+	msg.ChatID, _ = strconv.Atoi(params.Get("chat_id"))
+
+	if markup := params.Get("reply_markup"); markup != "" {
+		msg.ReplyMarkup = &telegram.InlineKeyboardMarkup{}
+		_ = json.Unmarshal([]byte(markup), msg.ReplyMarkup)
+	}
+
+	s.sent = append(s.sent, msg)
+
+	return &telegram.IncomingMessage{
+		ID:   msg.ID,
+		Text: msg.Text,
+		Chat: telegram.Chat{ID: msg.ChatID},
+	}
+}
+
+// serveFile writes the contents of a file downloaded by the bot
+func (s *Server) serveFile(w http.ResponseWriter, filePath string) {
+	s.mu.Lock()
+	data, ok := s.files[strings.TrimPrefix(filePath, "documents/")]
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, nil)
+		return
+	}
+
+	_, _ = w.Write(data)
+}
+
+// sendDocument records a file uploaded by the bot and returns the message it is sent in
+func (s *Server) sendDocument(r *http.Request) (*telegram.IncomingMessage, error) {
+	file, header, err := r.FormFile("document")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := Document{
+		FileName: header.Filename,
+		Data:     data,
+		Caption:  r.FormValue("caption"),
+	}
+	doc.ChatID, _ = strconv.Atoi(r.FormValue("chat_id"))
+
+	s.documents = append(s.documents, doc)
+
+	msg := &telegram.IncomingMessage{
+		ID:      s.nextMessageID,
+		Caption: doc.Caption,
+		Chat:    telegram.Chat{ID: doc.ChatID},
+	}
+	s.nextMessageID++
+
+	return msg, nil
+}
+
+// editMessage applies an edit of the text or keyboard to a sent message, reporting whether it was found
+func (s *Server) editMessage(method string, params url.Values) bool {
+	chatID, _ := strconv.Atoi(params.Get("chat_id"))
+	messageID, _ := strconv.Atoi(params.Get("message_id"))
+
+	for i := range s.sent {
+		msg := &s.sent[i]
+		if msg.ID != messageID || msg.ChatID != chatID {
+			continue
+		}
+
+		if method == "editMessageText" {
+			msg.Text = params.Get("text")
+		}
+
+		msg.ReplyMarkup = nil
+		if markup := params.Get("reply_markup"); markup != "" {
+			msg.ReplyMarkup = &telegram.InlineKeyboardMarkup{}
+			_ = json.Unmarshal([]byte(markup), msg.ReplyMarkup)
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// addUpdate assigns the next ID to the update and queues it. It must be called with mu locked
+func (s *Server) addUpdate(upd telegram.Update) telegram.Update {
+	upd.ID = s.nextUpdateID
+	s.nextUpdateID++
+
+	s.updates = append(s.updates, upd)
+
+	return upd
+}
+
+// message creates a message with the next ID in the private chat with the user. It must be called with mu locked
+func (s *Server) message(userID int, userName, text string) *telegram.IncomingMessage {
+	msg := &telegram.IncomingMessage{
+		ID:   s.nextMessageID,
+		Text: text,
+		From: telegram.From{ID: userID, UserName: userName},
+		Chat: telegram.Chat{ID: userID},
+	}
+	s.nextMessageID++
+
+	return msg
+}
+
+func (s *Server) addFailure(method string, failure Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[method] = append(s.failures[method], failure)
+}
+
+// writeResult replies with a successful response carrying result
+func writeResult(w http.ResponseWriter, result interface{}) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		writeError(w, Failure{Code: http.StatusInternalServerError, Description: err.Error()})
+		return
+	}
+
+	writeResponse(w, http.StatusOK, telegram.Response{Ok: true, Result: data})
+}
+
+// writeError replies with the error response Telegram sends for the failure
+func writeError(w http.ResponseWriter, failure Failure) {
+	res := telegram.Response{
+		ErrorCode:   failure.Code,
+		Description: failure.Description,
+	}
+	if failure.RetryAfter > 0 {
+		res.Parameters = &telegram.ResponseParameters{RetryAfter: failure.RetryAfter}
+	}
+
+	writeResponse(w, failure.Code, res)
+}
+
+func writeResponse(w http.ResponseWriter, status int, res telegram.Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -1,33 +1,116 @@
 package telegram
 
+import "encoding/json"
+
 // Update represents a single update from the Telegram Bot API, typically containing
 // information about incoming messages or other events
 type Update struct {
-	ID      int              `json:"update_id"`
-	Message *IncomingMessage `json:"message"`
+	ID            int              `json:"update_id"`
+	Message       *IncomingMessage `json:"message"`
+	CallbackQuery *CallbackQuery   `json:"callback_query"`
 }
 
-// UpdatesResponse is the structure for the response returned by the "getUpdates" API,
-// containing a status and an array of updates
-type UpdatesResponse struct {
-	Ok     bool     `json:"ok"`
-	Result []Update `json:"result"`
+// Response is the envelope every Bot API method replies with. On success Result holds
+// the method-specific payload, otherwise ErrorCode and Description explain the failure
+type Response struct {
+	Ok          bool                `json:"ok"`
+	Result      json.RawMessage     `json:"result"`
+	ErrorCode   int                 `json:"error_code"`
+	Description string              `json:"description"`
+	Parameters  *ResponseParameters `json:"parameters"`
+}
+
+// ResponseParameters contains information about why a request was unsuccessful
+type ResponseParameters struct {
+	MigrateToChatID int `json:"migrate_to_chat_id"`
+	RetryAfter      int `json:"retry_after"`
 }
 
 // IncomingMessage represents the structure of a received message in an update, including
-// the message text, sender details, and chat information
+// the message text, sender details, and chat information. Media messages carry a Caption
+// instead of a Text, and ForwardOrigin is set on messages forwarded from elsewhere
 type IncomingMessage struct {
-	Text string `json:"text"`
-	From From   `json:"from"`
-	Chat Chat   `json:"chat"`
+	ID              int             `json:"message_id"`
+	Text            string          `json:"text"`
+	Entities        []MessageEntity `json:"entities,omitempty"`
+	Caption         string          `json:"caption,omitempty"`
+	CaptionEntities []MessageEntity `json:"caption_entities,omitempty"`
+	ForwardOrigin   *MessageOrigin  `json:"forward_origin,omitempty"`
+	Document        *Document       `json:"document,omitempty"`
+	From            From            `json:"from"`
+	Chat            Chat            `json:"chat"`
+}
+
+// Types of the message entities holding links
+const (
+	EntityURL      = "url"
+	EntityTextLink = "text_link"
+)
+
+// MessageEntity marks a special part of a message text or caption, such as a link.
+// Offset and Length are counted in UTF-16 code units. URL is only set for text links,
+// whose text differs from the link they open
+type MessageEntity struct {
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	URL    string `json:"url,omitempty"`
+}
+
+// Document is a file sent as a message, to be downloaded with DownloadFile
+type Document struct {
+	FileID   string `json:"file_id"`
+	FileName string `json:"file_name,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	FileSize int64  `json:"file_size,omitempty"`
+}
+
+// File is a file ready to be downloaded, as returned by getFile. FilePath is valid for at
+// least an hour
+type File struct {
+	FileID   string `json:"file_id"`
+	FileSize int64  `json:"file_size,omitempty"`
+	FilePath string `json:"file_path,omitempty"`
+}
+
+// MessageOrigin describes where a forwarded message was originally sent. Chat is set
+// for messages forwarded from channels and groups
+type MessageOrigin struct {
+	Type string `json:"type"`
+	Chat *Chat  `json:"chat,omitempty"`
+}
+
+// CallbackQuery represents a press of an inline keyboard button, carrying the button's
+// callback data and the message the keyboard is attached to
+type CallbackQuery struct {
+	ID      string           `json:"id"`
+	From    From             `json:"from"`
+	Message *IncomingMessage `json:"message"`
+	Data    string           `json:"data"`
 }
 
-// From contains information about the user who sent the message
+// From contains information about the user who sent the message. ID is stable while
+// UserName may be empty or change over time
 type From struct {
+	ID       int    `json:"id"`
 	UserName string `json:"username"`
 }
 
-// Chat contains details about the chat where the message was received
+// Chat contains details about the chat where the message was received. Title is only set
+// for groups and channels
 type Chat struct {
-	ID int `json:"id"`
+	ID    int    `json:"id"`
+	Title string `json:"title,omitempty"`
+}
+
+// InlineKeyboardMarkup represents an inline keyboard that appears right next to the message it belongs to
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// InlineKeyboardButton represents one button of an inline keyboard, sending CallbackData
+// back to the bot when pressed
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
 }
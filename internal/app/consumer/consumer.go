@@ -1,7 +1,10 @@
 package consumer
 
+import "context"
+
 // Consumer defines an interface with a Start method, which is expected to start the
-// event consumption process and return an error if there are any issues
+// event consumption process and return an error if there are any issues. Start runs
+// until ctx is cancelled and returns nil once it has shut down cleanly
 type Consumer interface {
-	Start() error
-}
\ No newline at end of file
+	Start(ctx context.Context) error
+}
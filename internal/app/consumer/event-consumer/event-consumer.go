@@ -1,63 +1,173 @@
 package event_consumer
 
 import (
+	"context"
+	"errors"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/Braendie/Telegram-bot/internal/app/events"
+	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
 )
 
-// Consumer represents an event consumer that fetches and processes events in batches
+// fetchErrorDelay is how long the consumer waits before fetching again after a failed fetch
+const fetchErrorDelay = time.Second
+
+var ErrShutdownTimeout = errors.New("in-flight events were not processed before the shutdown deadline")
+
+// Consumer represents an event consumer that fetches events in batches and processes them
+// with a pool of workers. Events are sharded by their key, so events sharing a key are
+// handled in order by the same worker while different keys are served in parallel
 type Consumer struct {
-	fetcher   events.Fetcher
-	processor events.Processor
-	batchSize int
+	fetcher         events.Fetcher
+	processor       events.Processor
+	batchSize       int
+	workers         int
+	queueSize       int
+	shutdownTimeout time.Duration
 }
 
-// New creates and returns a new Consumer with the given fetcher, processor, and batch size
-func New(fetcher events.Fetcher, processor events.Processor, batchSize int) Consumer {
+// New creates and returns a new Consumer with the given fetcher, processor, and batch size.
+// workers is the number of parallel workers, each with a queue of queueSize events.
+// shutdownTimeout bounds how long in-flight events may take to finish once Start is cancelled
+func New(fetcher events.Fetcher, processor events.Processor, batchSize, workers, queueSize int, shutdownTimeout time.Duration) Consumer {
 	return Consumer{
-		fetcher:   fetcher,
-		processor: processor,
-		batchSize: batchSize,
+		fetcher:         fetcher,
+		processor:       processor,
+		batchSize:       batchSize,
+		workers:         max(workers, 1),
+		queueSize:       max(queueSize, 1),
+		shutdownTimeout: shutdownTimeout,
+	}
+}
+
+// Start initiates the event consumption loop, fetching events in batches and dispatching them
+// to the workers. Fetching goes on while the queues have room, so a slow event holds up only
+// the events sharing its key; the fetcher keeps track of the events still in flight and never
+// confirms them to their source before they are handled. When ctx is cancelled it stops
+// fetching, lets the workers process the queued events for up to the shutdown timeout, waits
+// for them to stop and commits the offset. Events still queued at the deadline are skipped,
+// they were never committed and will be fetched again
+func (c Consumer) Start(ctx context.Context) error {
+	queues := make([]chan events.Event, c.workers)
+	deadline := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan events.Event, c.queueSize)
+
+		wg.Add(1)
+		go func(queue <-chan events.Event) {
+			defer wg.Done()
+
+			c.work(queue, deadline)
+		}(queues[i])
+	}
+
+	c.dispatch(ctx, queues)
+
+	for _, queue := range queues {
+		close(queue)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var stopErr error
+
+	select {
+	case <-done:
+	case <-time.After(c.shutdownTimeout):
+		close(deadline)
+		<-done
+
+		stopErr = e.Wrap("can't stop consumer", ErrShutdownTimeout)
+	}
+
+	if err := c.shutdown(); err != nil {
+		return errors.Join(stopErr, err)
 	}
+
+	return stopErr
 }
 
-// Start initiates the event consumption loop, fetching and processing events in batches
-func (c Consumer) Start() error {
+// dispatch fetches events and routes each one to the queue of its shard until ctx is cancelled.
+// A full queue blocks it, which stops fetching until the worker catches up
+func (c Consumer) dispatch(ctx context.Context, queues []chan events.Event) {
 	for {
-		gotEvents, err := c.fetcher.Fetch(c.batchSize)
+		// Fetch long polls, so an empty result just means the poll timed out
+		gotEvents, err := c.fetcher.Fetch(ctx, c.batchSize)
+		if ctx.Err() != nil {
+			return
+		}
+
 		if err != nil {
 			log.Printf("[ERR] consumer: %s", err.Error())
 
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(fetchErrorDelay):
+			}
+
 			continue
 		}
 
-		if len(gotEvents) == 0 {
-			time.Sleep(time.Second)
+		for _, event := range gotEvents {
+			select {
+			case queues[shard(event.Key, len(queues))] <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
 
+// work processes the events of a single queue in order. Once the deadline has passed the
+// events still queued are skipped
+func (c Consumer) work(queue <-chan events.Event, deadline <-chan struct{}) {
+	for event := range queue {
+		select {
+		case <-deadline:
 			continue
+		default:
 		}
 
-		if err := c.handleEvents(gotEvents); err != nil {
-			log.Print(err)
+		log.Printf("got new event: %s", event.Text)
 
-			continue
+		if err := c.processor.Process(event); err != nil {
+			log.Printf("can't handle event: %s", err.Error())
 		}
 	}
 }
 
-// handleEvents processes each event in the provided slice of events
-func (c *Consumer) handleEvents(events []events.Event) error {
-	for _, event := range events {
-		log.Printf("got new event: %s", event.Text)
+// shutdown acknowledges the processed events if the fetcher supports it, so they are
+// not delivered again after a restart
+func (c Consumer) shutdown() error {
+	committer, ok := c.fetcher.(events.Committer)
+	if !ok {
+		return nil
+	}
 
-		if err := c.processor.Process(event); err != nil {
-			log.Printf("can't handle event: %s", err.Error())
+	ctx, cancel := context.WithTimeout(context.Background(), c.shutdownTimeout)
+	defer cancel()
 
-			continue
-		}
+	if err := committer.Commit(ctx); err != nil {
+		return e.Wrap("can't stop consumer", err)
 	}
 
 	return nil
 }
+
+// shard maps an event key to one of n worker queues
+func shard(key, n int) int {
+	if key < 0 {
+		key = -key
+	}
+
+	return key % n
+}
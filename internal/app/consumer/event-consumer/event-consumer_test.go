@@ -0,0 +1,276 @@
+package event_consumer
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Braendie/Telegram-bot/internal/app/events"
+)
+
+// fetcher returns the events given by batch for every call, and waits for ctx to be
+// cancelled once batch returns none. It counts the calls and the commits
+type fetcher struct {
+	batch func(call int) []events.Event
+
+	mu      sync.Mutex
+	calls   int
+	commits int
+}
+
+func (f *fetcher) Fetch(ctx context.Context, limit int) ([]events.Event, error) {
+	f.mu.Lock()
+	f.calls++
+	res := f.batch(f.calls)
+	f.mu.Unlock()
+
+	if res == nil {
+		<-ctx.Done()
+
+		return nil, ctx.Err()
+	}
+
+	return res, nil
+}
+
+func (f *fetcher) Commit(ctx context.Context) error {
+	f.mu.Lock()
+	f.commits++
+	f.mu.Unlock()
+
+	return nil
+}
+
+func (f *fetcher) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls
+}
+
+// processor processes events with the given function and records them in order
+type processor struct {
+	process func(events.Event)
+
+	mu        sync.Mutex
+	processed []events.Event
+}
+
+func (p *processor) Process(event events.Event) error {
+	if p.process != nil {
+		p.process(event)
+	}
+
+	p.mu.Lock()
+	p.processed = append(p.processed, event)
+	p.mu.Unlock()
+
+	return nil
+}
+
+func (p *processor) Processed() []events.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]events.Event(nil), p.processed...)
+}
+
+// batches returns the batch function of a fetcher that returns the given batches in turn
+func batches(b ...[]events.Event) func(int) []events.Event {
+	return func(call int) []events.Event {
+		if call > len(b) {
+			return nil
+		}
+
+		return b[call-1]
+	}
+}
+
+// start runs the consumer in the background and returns a function that stops it and
+// returns the error Start returned
+func start(t *testing.T, c Consumer) func() error {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	res := make(chan error, 1)
+
+	go func() {
+		res <- c.Start(ctx)
+	}()
+
+	stop := func() error {
+		cancel()
+
+		select {
+		case err := <-res:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("consumer didn't stop")
+		}
+
+		return nil
+	}
+
+	t.Cleanup(func() { cancel() })
+
+	return stop
+}
+
+// waitFor fails the test unless cond is met within a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestKeyOrder(t *testing.T) {
+	var batch []events.Event
+	for i := range 50 {
+		batch = append(batch, events.Event{Key: i % 5, Text: strconv.Itoa(i)})
+	}
+
+	p := &processor{process: func(event events.Event) {
+		// Later events of a key finish sooner if they are not processed in order
+		time.Sleep(time.Duration(50-mustAtoi(t, event.Text)) * 10 * time.Microsecond)
+	}}
+	f := &fetcher{batch: batches(batch[:25], batch[25:])}
+
+	stop := start(t, New(f, p, 100, 3, 10, time.Second))
+	waitFor(t, "the events to be processed", func() bool { return len(p.Processed()) == len(batch) })
+
+	if err := stop(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	last := map[int]int{}
+	for _, event := range p.Processed() {
+		n := mustAtoi(t, event.Text)
+		if prev, ok := last[event.Key]; ok && n < prev {
+			t.Errorf("event %d of key %d processed after event %d", n, event.Key, prev)
+		}
+
+		last[event.Key] = n
+	}
+
+	if f.commits != 1 {
+		t.Errorf("got %d commits, want 1", f.commits)
+	}
+}
+
+func TestKeysInParallel(t *testing.T) {
+	release := make(chan struct{})
+
+	p := &processor{process: func(event events.Event) {
+		if event.Key == 0 {
+			<-release
+		}
+	}}
+	// The event of the other key comes in a later batch, so it is only processed while
+	// the slow one is running if fetching goes on meanwhile
+	f := &fetcher{batch: batches(
+		[]events.Event{{Key: 0, Text: "slow"}},
+		[]events.Event{{Key: 1, Text: "fast"}},
+	)}
+
+	stop := start(t, New(f, p, 100, 2, 10, time.Second))
+	waitFor(t, "the other key to be processed", func() bool { return len(p.Processed()) == 1 })
+
+	if got := p.Processed()[0].Text; got != "fast" {
+		t.Errorf("got %q processed first, want %q", got, "fast")
+	}
+
+	close(release)
+
+	if err := stop(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	if got := len(p.Processed()); got != 2 {
+		t.Errorf("got %d processed events, want 2", got)
+	}
+}
+
+func TestBackPressure(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	p := &processor{process: func(events.Event) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+
+		<-release
+	}}
+	f := &fetcher{batch: func(call int) []events.Event {
+		return []events.Event{{Key: 0, Text: strconv.Itoa(call)}}
+	}}
+
+	stop := start(t, New(f, p, 100, 1, 1, time.Second))
+	<-started
+
+	// The worker holds the first event and the queue the second, sending the third blocks
+	waitFor(t, "the queue to fill up", func() bool { return f.Calls() >= 3 })
+	time.Sleep(20 * time.Millisecond)
+
+	if calls := f.Calls(); calls != 3 {
+		t.Errorf("fetched %d times while the queue was full, want 3", calls)
+	}
+
+	close(release)
+
+	if err := stop(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+}
+
+func TestSkipAtDeadline(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	p := &processor{process: func(event events.Event) {
+		if event.Text == "1" {
+			close(started)
+			<-release
+		}
+	}}
+	f := &fetcher{batch: batches([]events.Event{{Key: 0, Text: "1"}, {Key: 0, Text: "2"}, {Key: 0, Text: "3"}})}
+
+	stop := start(t, New(f, p, 100, 1, 10, 20*time.Millisecond))
+	<-started
+
+	// The first event outlives the shutdown timeout, the ones queued behind it are skipped
+	time.AfterFunc(100*time.Millisecond, func() { close(release) })
+
+	if err := stop(); !errors.Is(err, ErrShutdownTimeout) {
+		t.Errorf("got error %v, want %v", err, ErrShutdownTimeout)
+	}
+
+	if got := p.Processed(); len(got) != 1 || got[0].Text != "1" {
+		t.Errorf("got processed events %+v, want only the first", got)
+	}
+
+	if f.commits != 1 {
+		t.Errorf("got %d commits, want 1", f.commits)
+	}
+}
+
+func mustAtoi(t *testing.T, s string) int {
+	t.Helper()
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatalf("Atoi(%q): %v", s, err)
+	}
+
+	return n
+}
@@ -0,0 +1,153 @@
+package webhook_consumer
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Braendie/Telegram-bot/internal/app/events"
+	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
+)
+
+const (
+	secretHeader      = "X-Telegram-Bot-Api-Secret-Token"
+	maxBodySize       = 1 << 20
+	readHeaderTimeout = 10 * time.Second
+)
+
+// ErrNoSecret is returned by Start when no secret token is configured. Without it anyone who
+// finds the webhook URL could post updates on behalf of any user
+var ErrNoSecret = errors.New("webhook secret is not set")
+
+// Webhook defines the Bot API calls needed to register and unregister the webhook
+type Webhook interface {
+	SetWebhook(webhookURL, secret string) error
+	DeleteWebhook() error
+}
+
+// Consumer represents an event consumer that receives updates pushed by Telegram over HTTP
+type Consumer struct {
+	webhook         Webhook
+	parser          events.Parser
+	processor       events.Processor
+	addr            string
+	url             string
+	secret          string
+	shutdownTimeout time.Duration
+}
+
+// New creates and returns a new Consumer listening on addr for updates sent to the public webhook URL.
+// shutdownTimeout bounds how long in-flight requests may take to finish once Start is cancelled
+func New(webhook Webhook, parser events.Parser, processor events.Processor, addr, webhookURL, secret string, shutdownTimeout time.Duration) Consumer {
+	return Consumer{
+		webhook:         webhook,
+		parser:          parser,
+		processor:       processor,
+		addr:            addr,
+		url:             webhookURL,
+		secret:          secret,
+		shutdownTimeout: shutdownTimeout,
+	}
+}
+
+// Start registers the webhook and serves incoming updates until ctx is cancelled or the
+// HTTP server fails, removing the webhook on the way out. It refuses to start without a secret
+func (c Consumer) Start(ctx context.Context) error {
+	if c.secret == "" {
+		return e.Wrap("can't start webhook consumer", ErrNoSecret)
+	}
+
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return e.Wrap("can't start webhook consumer", err)
+	}
+
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, c.handle)
+
+	if err := c.webhook.SetWebhook(c.url, c.secret); err != nil {
+		return e.Wrap("can't start webhook consumer", err)
+	}
+	defer func() {
+		if err := c.webhook.DeleteWebhook(); err != nil {
+			log.Printf("[ERR] consumer: %s", err.Error())
+		}
+	}()
+
+	server := &http.Server{
+		Addr:              c.addr,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return e.Wrap("can't serve webhook", err)
+	case <-ctx.Done():
+	}
+
+	// Shutdown stops accepting updates and waits for the handlers that are still processing
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), c.shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return e.Wrap("can't stop webhook consumer", err)
+	}
+
+	return nil
+}
+
+// handle verifies the secret token of a webhook request and passes the update to the processor
+func (c Consumer) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	token := r.Header.Get(secretHeader)
+	if c.secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(c.secret)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	event, err := c.parser.Parse(data)
+	if err != nil {
+		log.Printf("[ERR] consumer: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	log.Printf("got new event: %s", event.Text)
+
+	// Telegram retries every non-2xx response, so processing errors are only logged
+	if err := c.processor.Process(event); err != nil {
+		log.Printf("can't handle event: %s", err.Error())
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
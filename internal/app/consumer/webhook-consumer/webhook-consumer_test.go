@@ -0,0 +1,98 @@
+package webhook_consumer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Braendie/Telegram-bot/internal/app/events"
+)
+
+const testSecret = "s3cret"
+
+// parser turns the request body into the text of an event
+type parser struct{}
+
+func (parser) Parse(data []byte) (events.Event, error) {
+	return events.Event{Type: events.Message, Text: string(data)}, nil
+}
+
+// processor records the events passed to it
+type processor struct {
+	events []events.Event
+}
+
+func (p *processor) Process(event events.Event) error {
+	p.events = append(p.events, event)
+
+	return nil
+}
+
+// webhook fails the test if the webhook is registered
+type webhook struct {
+	t *testing.T
+}
+
+func (w webhook) SetWebhook(string, string) error {
+	w.t.Error("SetWebhook called")
+
+	return nil
+}
+
+func (w webhook) DeleteWebhook() error {
+	return nil
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		wantStatus int
+		wantEvents int
+	}{
+		{"valid update", http.MethodPost, testSecret, http.StatusOK, 1},
+		{"missing token", http.MethodPost, "", http.StatusUnauthorized, 0},
+		{"wrong token", http.MethodPost, "guess", http.StatusUnauthorized, 0},
+		{"not a POST", http.MethodGet, testSecret, http.StatusMethodNotAllowed, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &processor{}
+			c := New(webhook{t}, parser{}, p, ":0", "https://example.com/hook", testSecret, time.Second)
+
+			r := httptest.NewRequest(tt.method, "/hook", strings.NewReader("update"))
+			if tt.token != "" {
+				r.Header.Set(secretHeader, tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			c.handle(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if len(p.events) != tt.wantEvents {
+				t.Fatalf("got %d processed events, want %d", len(p.events), tt.wantEvents)
+			}
+
+			if tt.wantEvents > 0 && p.events[0].Text != "update" {
+				t.Errorf("got event %+v", p.events[0])
+			}
+		})
+	}
+}
+
+func TestStartWithoutSecret(t *testing.T) {
+	c := New(webhook{t}, parser{}, &processor{}, ":0", "https://example.com/hook", "", time.Second)
+
+	if err := c.Start(context.Background()); !errors.Is(err, ErrNoSecret) {
+		t.Errorf("got error %v, want %v", err, ErrNoSecret)
+	}
+}
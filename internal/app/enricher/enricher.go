@@ -0,0 +1,137 @@
+// Package enricher fetches saved pages in the background and stores what they tell about
+// themselves: their title, description, site name and canonical URL
+package enricher
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+)
+
+// Enricher runs a pool of workers fetching the metadata of queued pages and storing it
+type Enricher struct {
+	fetcher *Fetcher
+	storage storage.Storage
+
+	// ctx is cancelled once Close gives up waiting, aborting the fetches still running
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan *storage.Page
+	wg     sync.WaitGroup
+}
+
+// New creates an Enricher and starts its workers. Up to queueSize pages wait to be fetched,
+// pages queued beyond it are dropped
+func New(fetcher *Fetcher, s storage.Storage, workers, queueSize int) *Enricher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	en := &Enricher{
+		fetcher: fetcher,
+		storage: s,
+		ctx:     ctx,
+		cancel:  cancel,
+		queue:   make(chan *storage.Page, queueSize),
+	}
+
+	for i := 0; i < workers; i++ {
+		en.wg.Add(1)
+		go en.work()
+	}
+
+	return en
+}
+
+// Enqueue schedules fetching the metadata of a saved page without waiting for it. It
+// reports whether the page was queued, which it isn't when the queue is full or closed.
+// A nil Enricher queues nothing, so it can stand for disabled enrichment
+func (en *Enricher) Enqueue(p *storage.Page) bool {
+	if en == nil {
+		return false
+	}
+
+	en.mu.Lock()
+	defer en.mu.Unlock()
+
+	if en.closed {
+		return false
+	}
+
+	page := *p
+
+	select {
+	case en.queue <- &page:
+		return true
+	default:
+		log.Printf("[WARN] enricher: queue is full, skipping %s", p.URL)
+		return false
+	}
+}
+
+// Close stops accepting pages and waits until the queued ones are fetched or ctx is done.
+// Then the fetches still running are cancelled and the pages left in the queue are dropped,
+// Close returns once the workers have stopped
+func (en *Enricher) Close(ctx context.Context) {
+	if en == nil {
+		return
+	}
+
+	en.mu.Lock()
+	if !en.closed {
+		en.closed = true
+		close(en.queue)
+	}
+	en.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		en.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		en.cancel()
+		<-done
+	}
+
+	en.cancel()
+}
+
+func (en *Enricher) work() {
+	defer en.wg.Done()
+
+	for page := range en.queue {
+		if en.ctx.Err() != nil {
+			continue
+		}
+
+		if err := en.enrich(page); err != nil {
+			log.Printf("[WARN] enricher: %s: %s", page.URL, err.Error())
+		}
+	}
+}
+
+// enrich fetches the metadata of the page and stores it. A page deleted in the meantime is skipped
+func (en *Enricher) enrich(page *storage.Page) error {
+	meta, err := en.fetcher.Fetch(en.ctx, page.URL)
+	if err != nil {
+		return err
+	}
+
+	if meta == (storage.Metadata{}) {
+		return nil
+	}
+
+	if err := en.storage.SetMeta(page, meta); err != nil && !errors.Is(err, storage.ErrPageNotFound) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package enricher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Braendie/Telegram-bot/internal/app/enricher"
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/memory"
+)
+
+const (
+	testTimeout = time.Second
+	testMaxSize = 4096
+)
+
+// newServer serves the page at every path, with the content type if it is set
+func newServer(t *testing.T, contentType, page string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		_, _ = w.Write([]byte(page))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func newFetcher() *enricher.Fetcher {
+	return enricher.NewFetcher(testTimeout, testMaxSize, true)
+}
+
+func TestFetch(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		page        string
+		want        storage.Metadata
+	}{
+		{
+			name:        "title",
+			contentType: "text/html; charset=utf-8",
+			page: `<html><head><title>
+				Go &amp; databases
+			</title><meta name="description" content="How to use databases"></head><body></body></html>`,
+			want: storage.Metadata{Title: "Go & databases", Description: "How to use databases"},
+		},
+		{
+			name:        "open graph",
+			contentType: "text/html",
+			page: `<!DOCTYPE html><html><head>
+				<title>Fallback</title>
+				<meta name="description" content="Fallback">
+				<meta property="og:title" content="The Go Blog">
+				<meta property="og:description" content="Posts about Go">
+				<meta property="og:site_name" content="Go">
+				<meta property="og:url" content="https://go.dev/blog/">
+			</head></html>`,
+			want: storage.Metadata{
+				Title:        "The Go Blog",
+				Description:  "Posts about Go",
+				SiteName:     "Go",
+				CanonicalURL: "https://go.dev/blog/",
+			},
+		},
+		{
+			name: "canonical link",
+			page: `<head><link rel="alternate" href="/feed"><link rel="Canonical" href="/post?id=1">
+				<meta property="og:url" content="https://example.com/other"></head>`,
+			want: storage.Metadata{CanonicalURL: "/post?id=1"},
+		},
+		{
+			name:        "charset of the page",
+			contentType: "text/html",
+			page:        "<head><meta charset=\"windows-1251\"><title>\xcf\xf0\xe8\xe2\xe5\xf2</title></head>",
+			want:        storage.Metadata{Title: "Привет"},
+		},
+		{
+			name:        "body is not searched",
+			contentType: "text/html",
+			page:        `<head></head><body><title>Not a title</title></body>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t, tt.contentType, tt.page)
+
+			// Canonical URLs are resolved against the page
+			if strings.HasPrefix(tt.want.CanonicalURL, "/") {
+				tt.want.CanonicalURL = srv.URL + tt.want.CanonicalURL
+			}
+
+			got, err := newFetcher().Fetch(context.Background(), srv.URL+"/page")
+			if err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchSizeLimit(t *testing.T) {
+	padding := "<!--" + strings.Repeat(" ", testMaxSize) + "-->"
+	srv := newServer(t, "text/html", "<head><meta name=\"description\" content=\"Before\">"+padding+"<title>After</title></head>")
+
+	got, err := newFetcher().Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	if got.Title != "" || got.Description != "Before" {
+		t.Errorf("got %+v, want only the description found before the limit", got)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+
+	start := time.Now()
+
+	f := enricher.NewFetcher(100*time.Millisecond, testMaxSize, true)
+	if _, err := f.Fetch(context.Background(), srv.URL); err == nil {
+		t.Fatal("Fetch of a page that never answers succeeded")
+	}
+
+	if elapsed := time.Since(start); elapsed > testTimeout {
+		t.Errorf("Fetch gave up after %s", elapsed)
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	srv := newServer(t, "application/pdf", "%PDF-1.4")
+
+	if _, err := newFetcher().Fetch(context.Background(), srv.URL); !errors.Is(err, enricher.ErrNotHTML) {
+		t.Errorf("Fetch of a PDF: got error %v, want %v", err, enricher.ErrNotHTML)
+	}
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(notFound.Close)
+
+	if _, err := newFetcher().Fetch(context.Background(), notFound.URL); err == nil {
+		t.Error("Fetch of a missing page succeeded")
+	}
+
+	f := enricher.NewFetcher(testTimeout, testMaxSize, false)
+	if _, err := f.Fetch(context.Background(), srv.URL); !errors.Is(err, enricher.ErrPrivateAddress) {
+		t.Errorf("Fetch from a loopback address: got error %v, want %v", err, enricher.ErrPrivateAddress)
+	}
+}
+
+func TestEnricher(t *testing.T) {
+	srv := newServer(t, "text/html", `<head><title>Example</title></head>`)
+
+	s := memory.New()
+	page := &storage.Page{URL: srv.URL + "/a", UserID: 1}
+	if err := s.Save(page); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	en := enricher.New(newFetcher(), s, 2, 10)
+
+	if !en.Enqueue(page) {
+		t.Fatal("Enqueue: page is not queued")
+	}
+	// A page removed before it is fetched is skipped
+	en.Enqueue(&storage.Page{URL: srv.URL + "/removed", UserID: 1})
+
+	en.Close(context.Background())
+
+	if en.Enqueue(page) {
+		t.Error("Enqueue: page is queued after Close")
+	}
+
+	got, err := s.PickRandom(1)
+	if err != nil {
+		t.Fatalf("PickRandom: %v", err)
+	}
+
+	if got.Meta.Title != "Example" {
+		t.Errorf("got metadata %+v, want the title of the page", got.Meta)
+	}
+}
+
+func TestEnricherCloseDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+
+	en := enricher.New(enricher.NewFetcher(time.Minute, testMaxSize, true), memory.New(), 1, 10)
+	for i := 0; i < 3; i++ {
+		en.Enqueue(&storage.Page{URL: fmt.Sprintf("%s/%d", srv.URL, i), UserID: 1})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	en.Close(ctx)
+
+	if elapsed := time.Since(start); elapsed > testTimeout {
+		t.Errorf("Close returned after %s, want it to give up at the deadline", elapsed)
+	}
+}
@@ -0,0 +1,249 @@
+package enricher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// maxRedirects is the number of redirects followed before giving up on a page
+	maxRedirects = 5
+
+	// maxFieldLen is the number of characters kept of every metadata field, pages sometimes
+	// put whole articles into their descriptions
+	maxFieldLen = 300
+
+	userAgent = "Mozilla/5.0 (compatible; Telegram-bot link preview)"
+)
+
+var (
+	ErrNotHTML        = errors.New("not an HTML page")
+	ErrPrivateAddress = errors.New("private network address")
+)
+
+// Fetcher downloads pages and extracts their metadata
+type Fetcher struct {
+	client  http.Client
+	maxSize int64
+}
+
+// NewFetcher creates a Fetcher. timeout bounds every fetch as a whole, and only the first
+// maxSize bytes of a page are read, which is plenty for its head. Unless allowPrivate is
+// set, pages on loopback and private network addresses are refused, so users can't make the
+// bot probe the network it runs in
+func NewFetcher(timeout time.Duration, maxSize int64, allowPrivate bool) *Fetcher {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = refusePrivate
+	}
+
+	return &Fetcher{
+		client: http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: timeout,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxRedirects)
+				}
+				return nil
+			},
+		},
+		maxSize: maxSize,
+	}
+}
+
+// Fetch downloads the page and returns the metadata found in it. A URL saved without a
+// scheme is fetched over HTTP
+func (f *Fetcher) Fetch(ctx context.Context, pageURL string) (storage.Metadata, error) {
+	if !strings.HasPrefix(pageURL, "http://") && !strings.HasPrefix(pageURL, "https://") {
+		pageURL = "http://" + pageURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return storage.Metadata{}, e.Wrap("can't fetch page", err)
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return storage.Metadata{}, e.Wrap("can't fetch page", err)
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return storage.Metadata{}, fmt.Errorf("can't fetch page: unexpected status %s", resp.Status)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); contentType != "" &&
+		(err != nil || mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+		return storage.Metadata{}, e.Wrap("can't fetch page", ErrNotHTML)
+	}
+
+	// The charset is taken from the header or the page itself, the metadata is stored as UTF-8
+	body, err := charset.NewReader(io.LimitReader(resp.Body, f.maxSize), contentType)
+	if err != nil {
+		return storage.Metadata{}, e.Wrap("can't fetch page", err)
+	}
+
+	return parseMeta(body, resp.Request.URL), nil
+}
+
+// parseMeta extracts the metadata from the head of an HTML page found at base. The Open
+// Graph title and description are preferred over the <title> and the description meta tag.
+// A page cut short by the size limit gives whatever was found before the cut
+func parseMeta(r io.Reader, base *url.URL) storage.Metadata {
+	var (
+		meta                      storage.Metadata
+		title, description, ogURL string
+		ogTitle, ogDescription    string
+		inTitle                   bool
+	)
+
+	z := html.NewTokenizer(r)
+
+loop:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			break loop
+		case html.TextToken:
+			if inTitle {
+				title += string(z.Text())
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = false
+			case "head":
+				break loop
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			attrs := map[string]string{}
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				attrs[string(key)] = string(val)
+			}
+
+			switch string(name) {
+			case "title":
+				inTitle = true
+			case "body":
+				break loop
+			case "link":
+				if hasToken(attrs["rel"], "canonical") && meta.CanonicalURL == "" {
+					meta.CanonicalURL = resolve(base, attrs["href"])
+				}
+			case "meta":
+				property := strings.ToLower(attrs["property"])
+				if property == "" {
+					property = strings.ToLower(attrs["name"])
+				}
+
+				switch property {
+				case "og:title":
+					ogTitle = attrs["content"]
+				case "og:description":
+					ogDescription = attrs["content"]
+				case "og:site_name":
+					meta.SiteName = attrs["content"]
+				case "og:url":
+					ogURL = attrs["content"]
+				case "description":
+					description = attrs["content"]
+				}
+			}
+		}
+	}
+
+	meta.Title = clean(firstNonEmpty(ogTitle, title))
+	meta.Description = clean(firstNonEmpty(ogDescription, description))
+	meta.SiteName = clean(meta.SiteName)
+	if meta.CanonicalURL == "" && ogURL != "" {
+		meta.CanonicalURL = resolve(base, ogURL)
+	}
+
+	return meta
+}
+
+// resolve returns the absolute HTTP(S) URL the reference found at base points to, or an empty string
+func resolve(base *url.URL, ref string) string {
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+
+	return u.String()
+}
+
+// hasToken reports whether the space separated list of an attribute such as rel contains the token
+func hasToken(list, token string) bool {
+	for _, t := range strings.Fields(list) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// clean collapses the whitespace of a metadata field and cuts it to maxFieldLen characters
+func clean(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= maxFieldLen {
+		return s
+	}
+
+	return string([]rune(s)[:maxFieldLen-1]) + "…"
+}
+
+// refusePrivate is a dialer control refusing connections to addresses that aren't public
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return e.Wrap("can't connect to "+address, ErrPrivateAddress)
+	}
+
+	return nil
+}
@@ -0,0 +1,330 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/Braendie/Telegram-bot/internal/app/clients/telegram"
+	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+)
+
+// Callback actions carried in the callback data of inline keyboard buttons
+const (
+	ReadAction   = "read"
+	KeepAction   = "keep"
+	NextAction   = "next"
+	DeleteAction = "delete"
+	EditAction   = "edit"
+	SearchAction = "search"
+	ListAction   = "list"
+)
+
+// Directions of the list buttons, followed by the ID of the first or last page shown
+const (
+	listBefore = "b"
+	listAfter  = "a"
+)
+
+// maxCallbackData is the maximum size of callback data Telegram accepts, in bytes
+const maxCallbackData = 64
+
+// doCallback processes a press of an inline keyboard button, parsing the action and executing it
+func (p *Processor) doCallback(data string, meta Meta) error {
+	log.Printf("got new callback '%s' from '%s' (%d)", data, meta.UserName, meta.UserID)
+
+	action, arg, _ := strings.Cut(data, ":")
+
+	switch action {
+	case ReadAction:
+		return p.markShownPageRead(meta)
+	case KeepAction:
+		return p.finishCallback(meta, msgKept)
+	case NextAction:
+		if err := p.finishCallback(meta, ""); err != nil {
+			return err
+		}
+		if arg != "" {
+			return p.sendTagRandom(meta.ChatID, meta.UserID, arg)
+		}
+		return p.sendRandom(meta.ChatID, meta.UserID)
+	case DeleteAction:
+		return p.removeShownPage(meta, msgDeleted)
+	case EditAction:
+		return p.sendEditHint(meta)
+	case SearchAction:
+		return p.turnSearchPage(meta, arg)
+	case ListAction:
+		return p.turnListPage(meta, arg)
+	default:
+		return p.tg.AnswerCallbackQuery(meta.CallbackID, msgUnknownAction)
+	}
+}
+
+// markShownPageRead moves the page shown in the message the button belongs to into the history
+func (p *Processor) markShownPageRead(meta Meta) error {
+	page := &storage.Page{
+		URL:    shownURL(meta.MessageText),
+		UserID: meta.UserID,
+	}
+
+	if err := p.storage.MarkRead(page); err != nil && !errors.Is(err, storage.ErrPageNotFound) {
+		return e.Wrap("can't do callback: mark page as read", err)
+	}
+
+	return p.finishCallback(meta, msgMarkedRead)
+}
+
+// removeShownPage removes the page shown in the message the button belongs to
+func (p *Processor) removeShownPage(meta Meta, notification string) error {
+	page := &storage.Page{
+		URL:    shownURL(meta.MessageText),
+		UserID: meta.UserID,
+	}
+
+	if err := p.storage.Remove(page); err != nil && !errors.Is(err, storage.ErrPageNotFound) {
+		return e.Wrap("can't do callback: remove page", err)
+	}
+
+	return p.finishCallback(meta, notification)
+}
+
+// sendEditHint tells how to edit the page shown in the message the button belongs to. The
+// buttons are left in place, so the page can still be deleted
+func (p *Processor) sendEditHint(meta Meta) error {
+	page, err := p.storage.Find(&storage.Page{
+		URL:    shownURL(meta.MessageText),
+		UserID: meta.UserID,
+	})
+	if err != nil && !errors.Is(err, storage.ErrPageNotFound) {
+		return e.Wrap("can't do callback: send edit hint", err)
+	}
+
+	if errors.Is(err, storage.ErrPageNotFound) {
+		return p.finishCallback(meta, msgPageNotFound)
+	}
+
+	if err := p.tg.AnswerCallbackQuery(meta.CallbackID, ""); err != nil {
+		return e.Wrap("can't do callback: send edit hint", err)
+	}
+
+	if err := p.tg.SendMessage(meta.ChatID, fmt.Sprintf(msgEditHint, page.ID, page.ID)); err != nil {
+		return e.Wrap("can't do callback: send edit hint", err)
+	}
+
+	return nil
+}
+
+// turnSearchPage replaces the search results shown in the message with the ones starting at offset
+func (p *Processor) turnSearchPage(meta Meta, offset string) error {
+	n, err := strconv.Atoi(offset)
+	if err != nil || n < 0 {
+		return p.tg.AnswerCallbackQuery(meta.CallbackID, msgUnknownAction)
+	}
+
+	text, keyboard, err := p.searchPage(meta.UserID, searchQuery(meta.MessageText), n)
+	if err != nil && !errors.Is(err, storage.ErrNoSavedPages) {
+		return e.Wrap("can't do callback: turn search page", err)
+	}
+
+	if errors.Is(err, storage.ErrNoSavedPages) {
+		return p.finishCallback(meta, msgNothingFound)
+	}
+
+	if err := p.tg.AnswerCallbackQuery(meta.CallbackID, ""); err != nil {
+		return e.Wrap("can't do callback: turn search page", err)
+	}
+
+	if err := p.tg.EditMessageText(context.Background(), meta.ChatID, meta.MessageID, text, keyboard); err != nil {
+		return e.Wrap("can't do callback: turn search page", err)
+	}
+
+	return nil
+}
+
+// turnListPage replaces the pages shown in the /list message with the ones before or after
+// the page whose ID the pressed button carries. If no page is left that way, as the pages
+// were deleted meanwhile, the list is shown from the start again
+func (p *Processor) turnListPage(meta Meta, arg string) error {
+	var cursor storage.Cursor
+
+	switch {
+	case strings.HasPrefix(arg, listBefore):
+		cursor.Backward = true
+		arg = strings.TrimPrefix(arg, listBefore)
+	case strings.HasPrefix(arg, listAfter):
+		arg = strings.TrimPrefix(arg, listAfter)
+	default:
+		return p.tg.AnswerCallbackQuery(meta.CallbackID, msgUnknownAction)
+	}
+
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return p.tg.AnswerCallbackQuery(meta.CallbackID, msgUnknownAction)
+	}
+	cursor.ID = id
+
+	tag := listTag(meta.MessageText)
+	notification := ""
+
+	text, keyboard, err := p.listPage(meta.UserID, tag, cursor)
+	if errors.Is(err, storage.ErrNoSavedPages) {
+		notification = msgListChanged
+		text, keyboard, err = p.listPage(meta.UserID, tag, storage.Cursor{})
+	}
+
+	if errors.Is(err, storage.ErrNoSavedPages) {
+		return p.finishCallback(meta, msgNothingFound)
+	}
+
+	if err != nil {
+		return e.Wrap("can't do callback: turn list page", err)
+	}
+
+	if err := p.tg.AnswerCallbackQuery(meta.CallbackID, notification); err != nil {
+		return e.Wrap("can't do callback: turn list page", err)
+	}
+
+	if err := p.tg.EditMessageText(context.Background(), meta.ChatID, meta.MessageID, text, keyboard); err != nil {
+		return e.Wrap("can't do callback: turn list page", err)
+	}
+
+	return nil
+}
+
+// finishCallback answers the callback query and removes the keyboard, so the buttons can't be pressed twice
+func (p *Processor) finishCallback(meta Meta, notification string) error {
+	if err := p.tg.AnswerCallbackQuery(meta.CallbackID, notification); err != nil {
+		return e.Wrap("can't finish callback", err)
+	}
+
+	if err := p.tg.EditMessageReplyMarkup(meta.ChatID, meta.MessageID, nil); err != nil {
+		return e.Wrap("can't finish callback", err)
+	}
+
+	return nil
+}
+
+// pageKeyboard builds the keyboard shown under a random page. A non-empty tag makes
+// the "Next" button pick the next page from the same tag
+func pageKeyboard(tag string) telegram.InlineKeyboardMarkup {
+	next := NextAction
+	if tag != "" && len(NextAction)+1+len(tag) <= maxCallbackData {
+		next = NextAction + ":" + tag
+	}
+
+	return telegram.InlineKeyboardMarkup{
+		InlineKeyboard: [][]telegram.InlineKeyboardButton{
+			{
+				{Text: btnMarkRead, CallbackData: ReadAction},
+				{Text: btnKeep, CallbackData: KeepAction},
+			},
+			{
+				{Text: btnNext, CallbackData: next},
+				{Text: btnDelete, CallbackData: DeleteAction},
+			},
+			{
+				{Text: btnEdit, CallbackData: EditAction},
+			},
+		},
+	}
+}
+
+// editKeyboard builds the keyboard shown under a page that has just been edited
+func editKeyboard() telegram.InlineKeyboardMarkup {
+	return telegram.InlineKeyboardMarkup{
+		InlineKeyboard: [][]telegram.InlineKeyboardButton{
+			{
+				{Text: btnEdit, CallbackData: EditAction},
+				{Text: btnDelete, CallbackData: DeleteAction},
+			},
+		},
+	}
+}
+
+// searchKeyboard builds the buttons turning the pages of search results, or returns nil
+// if all of them fit into one message
+func searchKeyboard(offset int, hasNext bool) *telegram.InlineKeyboardMarkup {
+	var row []telegram.InlineKeyboardButton
+
+	if offset > 0 {
+		row = append(row, telegram.InlineKeyboardButton{
+			Text:         btnPrev,
+			CallbackData: SearchAction + ":" + strconv.Itoa(max(offset-searchPageSize, 0)),
+		})
+	}
+
+	if hasNext {
+		row = append(row, telegram.InlineKeyboardButton{
+			Text:         btnNext,
+			CallbackData: SearchAction + ":" + strconv.Itoa(offset+searchPageSize),
+		})
+	}
+
+	if len(row) == 0 {
+		return nil
+	}
+
+	return &telegram.InlineKeyboardMarkup{
+		InlineKeyboard: [][]telegram.InlineKeyboardButton{row},
+	}
+}
+
+// listKeyboard builds the buttons moving through the list shown between the pages with
+// firstID and lastID, or returns nil if the whole list is shown
+func listKeyboard(firstID, lastID int, hasPrev, hasNext bool) *telegram.InlineKeyboardMarkup {
+	var row []telegram.InlineKeyboardButton
+
+	if hasPrev {
+		row = append(row, telegram.InlineKeyboardButton{
+			Text:         btnPrev,
+			CallbackData: ListAction + ":" + listBefore + strconv.Itoa(firstID),
+		})
+	}
+
+	if hasNext {
+		row = append(row, telegram.InlineKeyboardButton{
+			Text:         btnNext,
+			CallbackData: ListAction + ":" + listAfter + strconv.Itoa(lastID),
+		})
+	}
+
+	if len(row) == 0 {
+		return nil
+	}
+
+	return &telegram.InlineKeyboardMarkup{
+		InlineKeyboard: [][]telegram.InlineKeyboardButton{row},
+	}
+}
+
+// listTag returns the tag the list shown in a message is filtered by, which is in its
+// first line, or an empty string for the list of all pages
+func listTag(text string) string {
+	header, _, _ := strings.Cut(text, "\n")
+
+	tag, ok := strings.CutPrefix(strings.TrimPrefix(header, listHeader), "#")
+	if !ok {
+		return ""
+	}
+
+	return tag
+}
+
+// searchQuery returns the query of the search results shown in a message, which is always in its first line
+func searchQuery(text string) string {
+	header, _, _ := strings.Cut(text, "\n")
+
+	return strings.TrimPrefix(header, searchHeader)
+}
+
+// shownURL returns the URL of the page shown in a message, which is always its first line
+func shownURL(text string) string {
+	url, _, _ := strings.Cut(text, "\n")
+
+	return strings.TrimSpace(url)
+}
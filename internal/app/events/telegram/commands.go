@@ -1,46 +1,77 @@
 package telegram
 
 import (
+	"bytes"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Braendie/Telegram-bot/internal/app/clients/telegram"
+	"github.com/Braendie/Telegram-bot/internal/app/export"
+	"github.com/Braendie/Telegram-bot/internal/app/lib/canonical"
 	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
 	"github.com/Braendie/Telegram-bot/internal/app/storage"
 )
 
 // Command constants representing supported bot commands
 const (
-	RndCmd    = "/rnd"
-	HelpCmdEn = "/help_en"
-	HelpCmdRu = "/help_ru"
-	StartCmd  = "/start"
-	TagCmd    = "/tag"
-	RndTagCmd = "/rndtag"
+	RndCmd     = "/rnd"
+	HelpCmdEn  = "/help_en"
+	HelpCmdRu  = "/help_ru"
+	StartCmd   = "/start"
+	TagCmd     = "/tag"
+	RndTagCmd  = "/rndtag"
+	HistoryCmd = "/history"
+	RestoreCmd = "/restore"
+	TagsCmd    = "/tags"
+	SearchCmd  = "/search"
+	ListCmd    = "/list"
+	EditCmd    = "/edit"
+	DelCmd     = "/del"
+	ExportCmd  = "/export"
 )
 
+// descPrefix starts the description of a page saved without tags in the legacy format
+const descPrefix = "#desc:"
+
+// Fields /edit changes, written as field=value. The tags are separated by commas and the
+// description takes the rest of the message
+const (
+	editTags = "tag="
+	editDesc = "desc="
+)
+
+// historyLimit is the number of recently read pages shown by /history
+const historyLimit = 10
+
+// searchPageSize is the number of search results shown in one message
+const searchPageSize = 5
+
+// maxQueryLen is the longest search query kept, in UTF-16 code units. The query heads the
+// results message, a longer one would leave no room for them
+const maxQueryLen = 256
+
+// listPageSize is the number of pages shown in one message of /list
+const listPageSize = 10
+
 // doCmd processes a command received from the user, parsing the command type and executing the appropriate action
-func (p *Processor) doCmd(text string, chatID int, username string) error {
+func (p *Processor) doCmd(text string, chatID, userID int, username string) error {
 	text = strings.TrimSpace(text)
 
-	log.Printf("got new command '%s' from '%s'", text, username)
+	log.Printf("got new command '%s' from '%s' (%d)", text, username, userID)
 
 	words := strings.Split(text, " ")
 
 	if isAddCmd(words[0]) {
-		if len(words) < 2 {
-			return p.savePage(chatID, words[0], username, "", "")
-		} else if len(words) < 3 {
-			return p.savePage(chatID, words[0], username, words[1], "")
-		} else if strings.HasPrefix(words[1], "#desc:") {
-			return p.savePage(chatID, words[0], username, "", strings.TrimPrefix(strings.TrimPrefix(strings.Join(words[1:], " "), "#desc:"), " "))
-		}
+		tags, description := parsePageInfo(words[1:])
 
-		return p.savePage(chatID, words[0], username, words[1], strings.Join(words[2:], " "))
+		return p.savePage(chatID, words[0], userID, username, tags, description)
 	}
 
 	if len(text) != 0 {
@@ -51,7 +82,7 @@ func (p *Processor) doCmd(text string, chatID int, username string) error {
 
 	switch words[0] {
 	case RndCmd:
-		return p.sendRandom(chatID, username)
+		return p.sendRandom(chatID, userID)
 	case HelpCmdEn:
 		return p.sendHelpEn(chatID)
 	case StartCmd:
@@ -62,23 +93,86 @@ func (p *Processor) doCmd(text string, chatID int, username string) error {
 		if len(words) < 2 {
 			return p.tg.SendMessage(chatID, msgWrongTagCmd)
 		}
-		return p.sendTag(chatID, username, words[1])
+		return p.sendTag(chatID, userID, normalizeTag(words[1]))
 	case RndTagCmd:
 		if len(words) < 2 {
 			return p.tg.SendMessage(chatID, msgWrongRndTagCmd)
 		}
-		return p.sendTagRandom(chatID, username, words[1])
+		return p.sendTagRandom(chatID, userID, normalizeTag(words[1]))
+	case TagsCmd:
+		return p.sendTags(chatID, userID)
+	case SearchCmd:
+		if len(words) < 2 {
+			return p.tg.SendMessage(chatID, msgWrongSearchCmd)
+		}
+		return p.sendSearch(chatID, userID, strings.Join(words[1:], " "))
+	case ListCmd:
+		if len(words) < 2 {
+			return p.sendList(chatID, userID, "")
+		}
+		return p.sendList(chatID, userID, normalizeTag(words[1]))
+	case EditCmd:
+		if len(words) < 3 {
+			return p.tg.SendMessage(chatID, msgWrongEditCmd)
+		}
+		return p.editPage(chatID, userID, words[1], words[2:])
+	case DelCmd:
+		if len(words) < 2 {
+			return p.tg.SendMessage(chatID, msgWrongDelCmd)
+		}
+		return p.deletePage(chatID, userID, words[1])
+	case ExportCmd:
+		if len(words) < 2 {
+			return p.sendExport(chatID, userID, "")
+		}
+		return p.sendExport(chatID, userID, words[1])
+	case HistoryCmd:
+		return p.sendHistory(chatID, userID)
+	case RestoreCmd:
+		if len(words) < 2 {
+			return p.tg.SendMessage(chatID, msgWrongRestoreCmd)
+		}
+		return p.restorePage(chatID, userID, words[1])
 	default:
 		return p.tg.SendMessage(chatID, msgUnknownCommand)
 	}
 }
 
-// savePage stores a new page in the storage with optional tags and descriptions
-func (p *Processor) savePage(chatID int, pageURL, username, tag, description string) error {
+// parsePageInfo splits the words sent after a link into tags and a description. The leading
+// words starting with "#" are tags and the rest is the description, optionally starting
+// with "#desc:". Without such tags the legacy "tag description" format is understood
+func parsePageInfo(words []string) ([]string, string) {
+	var tags []string
+
+	for len(words) > 0 && strings.HasPrefix(words[0], "#") && !strings.HasPrefix(words[0], descPrefix) {
+		tags = append(tags, words[0])
+		words = words[1:]
+	}
+
+	if len(words) > 0 && strings.HasPrefix(words[0], descPrefix) {
+		return storage.NormalizeTags(tags), strings.TrimSpace(strings.TrimPrefix(strings.Join(words, " "), descPrefix))
+	}
+
+	if len(tags) == 0 && len(words) > 0 {
+		tags, words = words[:1], words[1:]
+	}
+
+	return storage.NormalizeTags(tags), strings.TrimSpace(strings.Join(words, " "))
+}
+
+// normalizeTag strips the "#" a tag may be sent with in commands
+func normalizeTag(tag string) string {
+	return strings.TrimLeft(tag, "#")
+}
+
+// savePage stores a new page in the storage with optional tags and descriptions. The page
+// is saved under its canonical URL, so other links to it are recognized as duplicates
+func (p *Processor) savePage(chatID int, pageURL string, userID int, username string, tags []string, description string) error {
 	page := &storage.Page{
-		URL:         pageURL,
+		URL:         canonical.URL(pageURL),
+		UserID:      userID,
 		UserName:    username,
-		Tag:         sql.NullString{String: tag, Valid: tag != ""},
+		Tags:        tags,
 		Description: sql.NullString{String: description, Valid: description != ""},
 	}
 
@@ -90,10 +184,18 @@ func (p *Processor) savePage(chatID int, pageURL, username, tag, description str
 		return p.tg.SendMessage(chatID, msgAlreadyExists)
 	}
 
-	if err := p.storage.Save(page); err != nil {
+	err = p.storage.Save(page)
+	if errors.Is(err, storage.ErrPageExists) {
+		return p.tg.SendMessage(chatID, msgAlreadyExists)
+	}
+	if err != nil {
 		return e.Wrap("can't do command: save page", err)
 	}
 
+	if p.enricher != nil {
+		p.enricher.Enqueue(page)
+	}
+
 	if err := p.tg.SendMessage(chatID, msgSaved); err != nil {
 		return e.Wrap("can't do command: save page", err)
 	}
@@ -101,9 +203,10 @@ func (p *Processor) savePage(chatID int, pageURL, username, tag, description str
 	return nil
 }
 
-// sendRandom retrieves a random page for the user and sends it as a message, then removes it from storage
-func (p *Processor) sendRandom(chatID int, userName string) error {
-	page, err := p.storage.PickRandom(userName)
+// sendRandom retrieves a random page for the user and sends it as a message with buttons to mark it as read,
+// keep it, get the next one or delete it
+func (p *Processor) sendRandom(chatID, userID int) error {
+	page, err := p.storage.PickRandom(userID)
 	if err != nil && !errors.Is(err, storage.ErrNoSavedPages) {
 		return e.Wrap("can't do command: send random", err)
 	}
@@ -112,27 +215,16 @@ func (p *Processor) sendRandom(chatID int, userName string) error {
 		return p.tg.SendMessage(chatID, msgNoSavedPages)
 	}
 
-	if page.Tag.Valid {
-		if !strings.HasPrefix(page.Tag.String, "#") {
-			page.Tag.String = "#" + page.Tag.String
-		}
-		page.Tag.String = "\n" + page.Tag.String
-	}
-
-	if page.Description.Valid {
-		page.Description.String = "\n" + page.Description.String
-	}
-
-	if err := p.tg.SendMessage(chatID, page.URL+page.Tag.String+page.Description.String); err != nil {
+	if err := p.tg.SendMessageWithKeyboard(chatID, pageText(page), pageKeyboard("")); err != nil {
 		return e.Wrap("can't do command: send random", err)
 	}
 
-	return p.storage.Remove(page)
+	return nil
 }
 
 // sendTag retrieves all pages associated with a specific tag for the user and sends them as a message
-func (p *Processor) sendTag(chatID int, userName, tag string) error {
-	pages, err := p.storage.PickTag(userName, tag)
+func (p *Processor) sendTag(chatID, userID int, tag string) error {
+	pages, err := p.storage.PickTag(userID, tag)
 	if err != nil && !errors.Is(err, storage.ErrNoSavedPages) {
 		return e.Wrap("can't do command: send random", err)
 	}
@@ -141,31 +233,29 @@ func (p *Processor) sendTag(chatID int, userName, tag string) error {
 		return p.tg.SendMessage(chatID, msgTagIsEmpty)
 	}
 
-	var message strings.Builder
-	message.WriteString("#")
-	message.WriteString(tag)
-	message.WriteString(":\n\n")
+	entries := make([]string, 0, len(pages))
 	for i, page := range pages {
-		if page.Description.Valid {
-			message.WriteString(fmt.Sprintf("%v) %s\n%s", i+1, page.URL, page.Description.String))
-		} else {
-			message.WriteString(fmt.Sprintf("%v) %s", i+1, page.URL))
+		entry := fmt.Sprintf("%v) %s", i+1, page.URL)
+		if title := pageTitle(page); title != "" {
+			entry += "\n" + title
 		}
-		if i != len(pages)-1 {
-			message.WriteString("\n\n")
+		if page.Description.Valid {
+			entry += "\n" + page.Description.String
 		}
+		entries = append(entries, entry)
 	}
 
-	if err := p.tg.SendMessage(chatID, message.String()); err != nil {
+	if err := p.sendEntries(chatID, "#"+tag+":\n\n", entries, "\n\n"); err != nil {
 		return e.Wrap("can't send tag pages", err)
 	}
 
 	return nil
 }
 
-// sendTagRandom retrieves a random page associated with a specific tag and sends it as a message
-func (p *Processor) sendTagRandom(chatID int, userName, tag string) error {
-	page, err := p.storage.PickTagRandom(userName, tag)
+// sendTagRandom retrieves a random page associated with a specific tag and sends it as a message with the same
+// buttons as sendRandom, where "Next" stays within the tag
+func (p *Processor) sendTagRandom(chatID, userID int, tag string) error {
+	page, err := p.storage.PickTagRandom(userID, tag)
 	if err != nil && !errors.Is(err, storage.ErrNoSavedPages) {
 		return e.Wrap("can't do command: send random", err)
 	}
@@ -174,15 +264,390 @@ func (p *Processor) sendTagRandom(chatID int, userName, tag string) error {
 		return p.tg.SendMessage(chatID, msgTagIsEmpty)
 	}
 
-	if page.Tag.Valid && !strings.HasPrefix("#", page.Tag.String) {
-		page.Tag.String = "#" + page.Tag.String
+	if err := p.tg.SendMessageWithKeyboard(chatID, pageText(page), pageKeyboard(tag)); err != nil {
+		return e.Wrap("can't do command: send random", err)
 	}
 
-	if err := p.tg.SendMessage(chatID, page.URL+"\n"+page.Tag.String+"\n"+page.Description.String); err != nil {
-		return e.Wrap("can't do command: send random", err)
+	return nil
+}
+
+// sendTags sends every tag of the user with the number of unread pages carrying it
+func (p *Processor) sendTags(chatID, userID int) error {
+	tags, err := p.storage.Tags(userID)
+	if err != nil && !errors.Is(err, storage.ErrNoSavedPages) {
+		return e.Wrap("can't do command: send tags", err)
+	}
+
+	if errors.Is(err, storage.ErrNoSavedPages) {
+		return p.tg.SendMessage(chatID, msgNoTags)
+	}
+
+	entries := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		entries = append(entries, fmt.Sprintf("#%s - %d", tag.Tag, tag.Count))
+	}
+
+	if err := p.sendEntries(chatID, msgTagsHeader, entries, "\n"); err != nil {
+		return e.Wrap("can't send tags", err)
+	}
+
+	return nil
+}
+
+// sendSearch sends the first page of the user's pages matching the query, with buttons
+// to turn the pages of the results
+func (p *Processor) sendSearch(chatID, userID int, query string) error {
+	query = strings.Join(strings.Fields(query), " ")
+	if textLen(query) > maxQueryLen {
+		query = cutText(query, maxQueryLen)[0]
+	}
+
+	text, keyboard, err := p.searchPage(userID, query, 0)
+	if err != nil && !errors.Is(err, storage.ErrNoSavedPages) {
+		return e.Wrap("can't do command: search", err)
+	}
+
+	if errors.Is(err, storage.ErrNoSavedPages) {
+		return p.tg.SendMessage(chatID, msgNothingFound)
+	}
+
+	if err := p.sendMessage(chatID, text, keyboard); err != nil {
+		return e.Wrap("can't send search results", err)
+	}
+
+	return nil
+}
+
+// searchPage builds the message showing the search results starting at offset. The first
+// line of the message carries the query, so the buttons can turn the pages without storing it
+func (p *Processor) searchPage(userID int, query string, offset int) (string, *telegram.InlineKeyboardMarkup, error) {
+	// One result more than shown tells whether there is a next page
+	pages, err := p.storage.Search(userID, query, offset, searchPageSize+1)
+	if err != nil {
+		return "", nil, err
+	}
+
+	hasNext := len(pages) > searchPageSize
+	if hasNext {
+		pages = pages[:searchPageSize]
+	}
+
+	entries := make([]string, 0, len(pages))
+	for i, page := range pages {
+		entries = append(entries, fmt.Sprintf("%v) %s", offset+i+1, pageText(page)))
+	}
+
+	text := joinEntries(searchHeader+query+"\n\n", entries, "\n\n", maxMessageLen)
+
+	return text, searchKeyboard(offset, hasNext), nil
+}
+
+// sendList sends the first unread pages of the user, only the ones carrying the tag if it
+// is set, with buttons to go through the rest
+func (p *Processor) sendList(chatID, userID int, tag string) error {
+	text, keyboard, err := p.listPage(userID, tag, storage.Cursor{})
+	if err != nil && !errors.Is(err, storage.ErrNoSavedPages) {
+		return e.Wrap("can't do command: list", err)
+	}
+
+	if errors.Is(err, storage.ErrNoSavedPages) {
+		if tag != "" {
+			return p.tg.SendMessage(chatID, msgTagIsEmpty)
+		}
+		return p.tg.SendMessage(chatID, msgNoSavedPages)
+	}
+
+	if err := p.sendMessage(chatID, text, keyboard); err != nil {
+		return e.Wrap("can't send list", err)
+	}
+
+	return nil
+}
+
+// listPage builds the message showing the unread pages from the cursor on, each with its ID.
+// The first line of the message carries the tag, so the buttons can move through the list
+func (p *Processor) listPage(userID int, tag string, cursor storage.Cursor) (string, *telegram.InlineKeyboardMarkup, error) {
+	// One page more than shown tells whether the list goes on in the direction of the cursor
+	pages, err := p.storage.ListPages(userID, storage.ListFilter{Tag: tag}, cursor, listPageSize+1)
+	if err != nil {
+		return "", nil, err
+	}
+
+	hasPrev, hasNext := cursor.ID != 0, len(pages) > listPageSize
+	if cursor.Backward {
+		hasPrev, hasNext = len(pages) > listPageSize, true
+	}
+
+	if len(pages) > listPageSize {
+		if cursor.Backward {
+			pages = pages[1:]
+		} else {
+			pages = pages[:listPageSize]
+		}
+	}
+
+	header := listHeader + msgListAll
+	if tag != "" {
+		header = listHeader + "#" + tag
+	}
+
+	entries := make([]string, 0, len(pages))
+	for _, page := range pages {
+		entries = append(entries, fmt.Sprintf("[%d] %s", page.ID, pageText(page)))
+	}
+
+	text := joinEntries(header+"\n\n", entries, "\n\n", maxMessageLen)
+
+	return text, listKeyboard(pages[0].ID, pages[len(pages)-1].ID, hasPrev, hasNext), nil
+}
+
+// sendHistory sends the pages the user has read most recently
+func (p *Processor) sendHistory(chatID, userID int) error {
+	pages, err := p.storage.History(userID, historyLimit)
+	if err != nil && !errors.Is(err, storage.ErrNoSavedPages) {
+		return e.Wrap("can't do command: send history", err)
+	}
+
+	if errors.Is(err, storage.ErrNoSavedPages) {
+		return p.tg.SendMessage(chatID, msgNoHistory)
+	}
+
+	entries := make([]string, 0, len(pages))
+	for i, page := range pages {
+		entry := fmt.Sprintf("%v) %s\n%s", i+1, page.URL, page.ReadAt.Time.Format(time.DateOnly))
+		if page.Description.Valid {
+			entry += " " + page.Description.String
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := p.sendEntries(chatID, msgHistoryHeader, entries, "\n\n"); err != nil {
+		return e.Wrap("can't send history", err)
+	}
+
+	return nil
+}
+
+// restorePage moves a read page back to the unread ones. The page is given either by
+// its number in the /history list or by its URL
+func (p *Processor) restorePage(chatID, userID int, ref string) error {
+	page := &storage.Page{
+		URL:    ref,
+		UserID: userID,
+	}
+
+	if n, err := strconv.Atoi(ref); err == nil {
+		pages, err := p.storage.History(userID, historyLimit)
+		if err != nil && !errors.Is(err, storage.ErrNoSavedPages) {
+			return e.Wrap("can't do command: restore page", err)
+		}
+
+		if n < 1 || n > len(pages) {
+			return p.tg.SendMessage(chatID, msgPageNotFound)
+		}
+
+		page = pages[n-1]
+	}
+
+	err := p.storage.Restore(page)
+	if err != nil && !errors.Is(err, storage.ErrPageNotFound) {
+		return e.Wrap("can't do command: restore page", err)
+	}
+
+	if errors.Is(err, storage.ErrPageNotFound) {
+		return p.tg.SendMessage(chatID, msgPageNotFound)
+	}
+
+	return p.tg.SendMessage(chatID, msgRestored)
+}
+
+// pageEdit holds the changes to a page requested by /edit, only the fields that are set change
+type pageEdit struct {
+	tags        []string
+	setTags     bool
+	description string
+	setDesc     bool
+}
+
+// parsePageEdit reads the fields /edit changes from the words sent after the page. It
+// returns false if there is a word that isn't a field or no field at all
+func parsePageEdit(words []string) (pageEdit, bool) {
+	var edit pageEdit
+
+	for i, word := range words {
+		switch {
+		case word == "":
+			continue
+		case strings.HasPrefix(word, editTags):
+			edit.tags = append(edit.tags, strings.Split(strings.TrimPrefix(word, editTags), ",")...)
+			edit.setTags = true
+		case strings.HasPrefix(word, editDesc):
+			edit.description = strings.TrimSpace(strings.TrimPrefix(strings.Join(words[i:], " "), editDesc))
+			edit.setDesc = true
+
+			return edit, true
+		default:
+			return pageEdit{}, false
+		}
+	}
+
+	return edit, edit.setTags
+}
+
+// findPage looks up a page of the user by its number from /list or by its link
+func (p *Processor) findPage(userID int, ref string) (*storage.Page, error) {
+	page := &storage.Page{
+		URL:    ref,
+		UserID: userID,
+	}
+
+	if id, err := strconv.Atoi(ref); err == nil {
+		page = &storage.Page{
+			ID:     id,
+			UserID: userID,
+		}
+	}
+
+	return p.storage.Find(page)
+}
+
+// editPage changes the tags or the description of a page and sends it back as it is now
+func (p *Processor) editPage(chatID, userID int, ref string, words []string) error {
+	edit, ok := parsePageEdit(words)
+	if !ok {
+		return p.tg.SendMessage(chatID, msgWrongEditCmd)
+	}
+
+	page, err := p.findPage(userID, ref)
+	if err != nil && !errors.Is(err, storage.ErrPageNotFound) {
+		return e.Wrap("can't do command: edit page", err)
+	}
+
+	if errors.Is(err, storage.ErrPageNotFound) {
+		return p.tg.SendMessage(chatID, msgPageNotFound)
+	}
+
+	if edit.setTags {
+		page.Tags = storage.NormalizeTags(edit.tags)
+	}
+
+	if edit.setDesc {
+		page.Description = sql.NullString{String: edit.description, Valid: edit.description != ""}
+	}
+
+	if err := p.storage.Update(page); err != nil {
+		return e.Wrap("can't do command: edit page", err)
+	}
+
+	if err := p.tg.SendMessageWithKeyboard(chatID, pageText(page), editKeyboard()); err != nil {
+		return e.Wrap("can't do command: edit page", err)
+	}
+
+	return nil
+}
+
+// deletePage removes a page given by its number from /list or by its link
+func (p *Processor) deletePage(chatID, userID int, ref string) error {
+	page, err := p.findPage(userID, ref)
+	if err == nil {
+		err = p.storage.RemoveByID(userID, page.ID)
+	}
+
+	if err != nil && !errors.Is(err, storage.ErrPageNotFound) {
+		return e.Wrap("can't do command: delete page", err)
+	}
+
+	if errors.Is(err, storage.ErrPageNotFound) {
+		return p.tg.SendMessage(chatID, msgPageNotFound)
+	}
+
+	return p.tg.SendMessage(chatID, msgDeleted)
+}
+
+// sendExport sends every page of the user as a file in the format, JSON by default
+func (p *Processor) sendExport(chatID, userID int, formatName string) error {
+	format, err := export.ParseFormat(formatName)
+	if err != nil {
+		return p.tg.SendMessage(chatID, msgWrongExportCmd)
+	}
+
+	pages, err := p.storage.Pages(userID)
+	if err != nil && !errors.Is(err, storage.ErrNoSavedPages) {
+		return e.Wrap("can't do command: send export", err)
+	}
+
+	if errors.Is(err, storage.ErrNoSavedPages) {
+		return p.tg.SendMessage(chatID, msgNoSavedPages)
+	}
+
+	var buf bytes.Buffer
+	if err := export.Write(&buf, format, pages); err != nil {
+		return e.Wrap("can't do command: send export", err)
+	}
+
+	caption := fmt.Sprintf(msgExported, len(pages))
+	if err := p.tg.SendDocument(chatID, export.FileName(format), buf.Bytes(), caption); err != nil {
+		return e.Wrap("can't do command: send export", err)
+	}
+
+	return nil
+}
+
+// sendMessage sends a text message with the keyboard attached, if there is one
+func (p *Processor) sendMessage(chatID int, text string, keyboard *telegram.InlineKeyboardMarkup) error {
+	if keyboard == nil {
+		return p.tg.SendMessage(chatID, text)
+	}
+
+	return p.tg.SendMessageWithKeyboard(chatID, text, *keyboard)
+}
+
+// pageText formats a page as its URL followed by its title, tags and description on separate
+// lines. The description the page gives is shown if the user didn't give one. The URL always
+// comes first, callbacks rely on it
+func pageText(page *storage.Page) string {
+	text := page.URL
+
+	if title := pageTitle(page); title != "" {
+		text += "\n" + title
+	}
+
+	if len(page.Tags) > 0 {
+		text += "\n" + formatTags(page.Tags)
+	}
+
+	if page.Description.Valid {
+		text += "\n" + page.Description.String
+	} else if page.Meta.Description != "" {
+		text += "\n" + page.Meta.Description
+	}
+
+	return text
+}
+
+// pageTitle returns the title of the page along with the site name, unless the title already
+// mentions it, or an empty string if the page hasn't been fetched
+func pageTitle(page *storage.Page) string {
+	title, site := page.Meta.Title, page.Meta.SiteName
+
+	switch {
+	case title == "":
+		return site
+	case site == "" || strings.Contains(strings.ToLower(title), strings.ToLower(site)):
+		return title
+	default:
+		return title + " — " + site
+	}
+}
+
+// formatTags joins the tags into a single line, each one starting with "#"
+func formatTags(tags []string) string {
+	res := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		res = append(res, "#"+tag)
 	}
 
-	return p.storage.Remove(page)
+	return strings.Join(res, " ")
 }
 
 // sendHelpEn sends the English help message to the user
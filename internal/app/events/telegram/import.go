@@ -0,0 +1,232 @@
+package telegram
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Braendie/Telegram-bot/internal/app/clients/telegram"
+	"github.com/Braendie/Telegram-bot/internal/app/importer"
+	"github.com/Braendie/Telegram-bot/internal/app/lib/canonical"
+	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+)
+
+const (
+	// maxImportSize is the largest file the Bot API lets bots download
+	maxImportSize = 20 << 20
+
+	// importBatchSize is the number of bookmarks saved at once
+	importBatchSize = 100
+
+	// importProgressInterval is the least time between two updates of the import progress,
+	// editing a message more often gets the bot flood-limited
+	importProgressInterval = 3 * time.Second
+)
+
+// startImport runs importDocument in the background, so a large file holds up neither the
+// other messages of the chat nor the webhook response. The update counts as processed once
+// the import has started, an update delivered again doesn't start another one
+func (p *Processor) startImport(meta Meta) {
+	p.importMu.Lock()
+	if id, ok := p.importedUpdates[meta.ChatID]; (ok && id == meta.UpdateID) || p.importsClosed {
+		p.importMu.Unlock()
+		return
+	}
+	p.importedUpdates[meta.ChatID] = meta.UpdateID
+	p.imports.Add(1)
+	p.importMu.Unlock()
+
+	go func() {
+		defer p.imports.Done()
+
+		if err := p.importDocument(p.importsCtx, p.importsStopped, meta); err != nil {
+			log.Printf("[ERR] import: %s", err.Error())
+		}
+	}()
+}
+
+// importDocument saves the bookmarks from a file sent by the user. The progress is shown
+// in a message edited as batches of bookmarks are saved, which ends up telling how many
+// were saved, already in the list or skipped for not being web pages, or that the import
+// failed. Requests are made with ctx, once stop is closed the import stops after the batch
+// being saved
+func (p *Processor) importDocument(ctx context.Context, stop <-chan struct{}, meta Meta) error {
+	if meta.Document.FileSize > maxImportSize {
+		return p.importReply(ctx, meta.ChatID, msgImportTooLarge)
+	}
+
+	data, err := p.tg.DownloadFile(ctx, meta.Document.FileID, maxImportSize)
+	if errors.Is(err, telegram.ErrFileTooLarge) {
+		return p.importReply(ctx, meta.ChatID, msgImportTooLarge)
+	}
+	if err != nil {
+		return p.failImport(ctx, meta.ChatID, 0, msgImportFailed, err)
+	}
+
+	res, err := importer.Parse(data)
+	if errors.Is(err, importer.ErrUnknownFormat) {
+		return p.importReply(ctx, meta.ChatID, msgImportUnknown)
+	}
+	if err != nil {
+		return p.failImport(ctx, meta.ChatID, 0, msgImportFailed, err)
+	}
+
+	total := len(res.Bookmarks)
+
+	progressID, err := p.tg.SendMessageID(ctx, meta.ChatID, fmt.Sprintf(msgImportStarted, total, res.Format))
+	if err != nil {
+		return e.Wrap("can't import document", err)
+	}
+
+	var (
+		saved, exists int
+		seen          = make(map[string]bool, total)
+		lastProgress  = time.Now()
+	)
+
+	for start := 0; start < total; start += importBatchSize {
+		select {
+		case <-stop:
+			text := fmt.Sprintf(msgImportStopped, start, total)
+			if err := p.tg.EditMessageText(ctx, meta.ChatID, progressID, text, nil); err != nil {
+				return e.Wrap("can't import document", err)
+			}
+
+			return nil
+		default:
+		}
+
+		batch := res.Bookmarks[start:min(start+importBatchSize, total)]
+
+		n, dup, err := p.importBatch(meta, batch, seen)
+		if err != nil {
+			return p.failImport(ctx, meta.ChatID, progressID, fmt.Sprintf(msgImportFailedAt, start, total), err)
+		}
+
+		saved += n
+		exists += dup
+
+		done := start + len(batch)
+		if done < total && time.Since(lastProgress) >= importProgressInterval {
+			text := fmt.Sprintf(msgImportProgress, done, total)
+			if err := p.tg.EditMessageText(ctx, meta.ChatID, progressID, text, nil); err != nil {
+				return e.Wrap("can't import document", err)
+			}
+
+			lastProgress = time.Now()
+		}
+	}
+
+	text := fmt.Sprintf(msgImported, res.Format, saved, exists, res.Skipped)
+	if err := p.tg.EditMessageText(ctx, meta.ChatID, progressID, text, nil); err != nil {
+		return e.Wrap("can't import document", err)
+	}
+
+	return nil
+}
+
+// importReply answers the file sent to import with the text
+func (p *Processor) importReply(ctx context.Context, chatID int, text string) error {
+	if _, err := p.tg.SendMessageID(ctx, chatID, text); err != nil {
+		return e.Wrap("can't import document", err)
+	}
+
+	return nil
+}
+
+// failImport tells the user the import failed with the text, in the progress message if it
+// has been sent already, and returns err
+func (p *Processor) failImport(ctx context.Context, chatID, progressID int, text string, err error) error {
+	err = e.Wrap("can't import document", err)
+
+	var notifyErr error
+	if progressID == 0 {
+		_, notifyErr = p.tg.SendMessageID(ctx, chatID, text)
+	} else {
+		notifyErr = p.tg.EditMessageText(ctx, chatID, progressID, text, nil)
+	}
+
+	if notifyErr != nil {
+		return errors.Join(err, e.Wrap("can't report failed import", notifyErr))
+	}
+
+	return err
+}
+
+// importBatch saves a batch of bookmarks, skipping the ones already in the list or seen
+// earlier in the file. Read bookmarks are marked read, the ones without a title are passed
+// to the enricher. It returns the number of bookmarks saved and the number skipped
+func (p *Processor) importBatch(meta Meta, batch []importer.Bookmark, seen map[string]bool) (int, int, error) {
+	pages := make([]*storage.Page, 0, len(batch))
+	bookmarks := make([]importer.Bookmark, 0, len(batch))
+	exists := 0
+
+	for _, b := range batch {
+		link := canonical.URL(b.URL)
+		if seen[link] {
+			exists++
+			continue
+		}
+		seen[link] = true
+
+		page := &storage.Page{
+			URL:         link,
+			UserID:      meta.UserID,
+			UserName:    meta.UserName,
+			Tags:        storage.NormalizeTags(b.Tags),
+			Description: sql.NullString{String: b.Description, Valid: b.Description != ""},
+		}
+
+		isExists, err := p.storage.IsExists(page)
+		if err != nil {
+			return 0, 0, e.Wrap("can't import batch", err)
+		}
+
+		if isExists {
+			exists++
+			continue
+		}
+
+		pages = append(pages, page)
+		bookmarks = append(bookmarks, b)
+	}
+
+	if len(pages) == 0 {
+		return 0, exists, nil
+	}
+
+	saved, err := p.storage.SaveAll(pages)
+	if err != nil {
+		return 0, 0, e.Wrap("can't import batch", err)
+	}
+
+	for i, page := range pages {
+		if page.ID == 0 {
+			continue
+		}
+
+		if bookmarks[i].Read {
+			if err := p.storage.MarkRead(page); err != nil {
+				return 0, 0, e.Wrap("can't import batch", err)
+			}
+		}
+
+		if bookmarks[i].Title != "" {
+			if err := p.storage.SetMeta(page, storage.Metadata{Title: bookmarks[i].Title}); err != nil {
+				return 0, 0, e.Wrap("can't import batch", err)
+			}
+
+			continue
+		}
+
+		if p.enricher != nil {
+			p.enricher.Enqueue(page)
+		}
+	}
+
+	return saved, exists + len(pages) - saved, nil
+}
@@ -0,0 +1,153 @@
+package telegram
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/Braendie/Telegram-bot/internal/app/clients/telegram"
+	"github.com/Braendie/Telegram-bot/internal/app/lib/canonical"
+	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+)
+
+// messageLinks returns the links to web pages marked in the text of a message, or in its
+// caption for media messages, in the order they appear. Links leading to the same page are
+// listed once, links with another scheme, such as tg:// or mailto:, are left out
+func messageLinks(msg *telegram.IncomingMessage) []string {
+	text, entities := msg.Text, msg.Entities
+	if text == "" {
+		text, entities = msg.Caption, msg.CaptionEntities
+	}
+
+	var (
+		links []string
+		units []uint16
+	)
+	seen := make(map[string]bool)
+
+	for _, entity := range entities {
+		var link string
+
+		switch entity.Type {
+		case telegram.EntityURL:
+			if units == nil {
+				units = utf16.Encode([]rune(text))
+			}
+			// Offsets are counted in UTF-16 code units
+			if entity.Offset < 0 || entity.Length <= 0 || entity.Offset+entity.Length > len(units) {
+				continue
+			}
+			link = string(utf16.Decode(units[entity.Offset : entity.Offset+entity.Length]))
+		case telegram.EntityTextLink:
+			// Telegram gives text links with their scheme, a link without one isn't a web page
+			if !isWebLink(entity.URL) {
+				continue
+			}
+			link = entity.URL
+		default:
+			continue
+		}
+
+		link = canonical.URL(link)
+		if !isWebLink(link) || seen[link] {
+			continue
+		}
+		seen[link] = true
+
+		links = append(links, link)
+	}
+
+	return links
+}
+
+// isWebLink reports whether the link has the http or https scheme
+func isWebLink(link string) bool {
+	lower := strings.ToLower(link)
+
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// linkPages returns the pages to save from a message holding a list of links, or nil if the
+// message is a command or a single link sent with its tags and description. Every line
+// starting with a link becomes a page with the tags and description written after the link,
+// every other link marked in the message becomes a page of its own. Forwarded messages are
+// only saved link by link, since their text was written by someone else
+func linkPages(text string, meta Meta) []*storage.Page {
+	text = strings.TrimSpace(text)
+	if !meta.Forwarded && strings.HasPrefix(text, "/") {
+		return nil
+	}
+
+	var pages []*storage.Page
+	seen := make(map[string]bool)
+
+	add := func(link string, tags []string, description string) {
+		link = canonical.URL(link)
+		if seen[link] {
+			return
+		}
+		seen[link] = true
+
+		pages = append(pages, &storage.Page{
+			URL:         link,
+			UserID:      meta.UserID,
+			UserName:    meta.UserName,
+			Tags:        tags,
+			Description: sql.NullString{String: description, Valid: description != ""},
+		})
+	}
+
+	if !meta.Forwarded {
+		for _, line := range strings.Split(text, "\n") {
+			words := strings.Fields(line)
+			if len(words) > 0 && isAddCmd(words[0]) {
+				tags, description := parsePageInfo(words[1:])
+				add(words[0], tags, description)
+			}
+		}
+	}
+
+	for _, link := range meta.Links {
+		add(link, nil, "")
+	}
+
+	// A single link is left to doCmd when it takes the message for a link to save
+	first, _, _ := strings.Cut(text, " ")
+	if !meta.Forwarded && len(pages) <= 1 && isAddCmd(first) {
+		return nil
+	}
+
+	return pages
+}
+
+// savePages stores the pages of a message in one go and replies with how many were saved
+// and how many were already in the list, listing the links of both
+func (p *Processor) savePages(chatID int, pages []*storage.Page) error {
+	saved, err := p.storage.SaveAll(pages)
+	if err != nil {
+		return e.Wrap("can't do command: save pages", err)
+	}
+
+	entries := make([]string, 0, len(pages))
+	for _, page := range pages {
+		if page.ID == 0 {
+			entries = append(entries, page.URL+msgLinkExists)
+			continue
+		}
+
+		if p.enricher != nil {
+			p.enricher.Enqueue(page)
+		}
+
+		entries = append(entries, page.URL)
+	}
+
+	header := fmt.Sprintf(msgSavedLinks, saved, len(pages)-saved)
+	if err := p.sendEntries(chatID, header, entries, "\n"); err != nil {
+		return e.Wrap("can't do command: save pages", err)
+	}
+
+	return nil
+}
@@ -2,48 +2,146 @@ package telegram
 
 const msgHelpEn = `I can save and keep your pages. Also I can offer you them to read.
 In order to save the page, just send me all link to it.
-You can also tag the link to make it easier to find in the future.
-Additionally, you can add a description to your link by writing it after the tag or using #desc:.`
+You can also tag the link with one or more tags to make it easier to find in the future.
+Additionally, you can add a description to your link by writing it after the tags or using #desc:.`
 
 const msgHelpRu = `Я могу сохранять и хранить ваши страницы. Также я могу предложить вам их для прочтения.
 Чтобы сохранить страницу, просто отправьте мне ссылку на неё.
-Вы также можете пометить ссылку тегом, чтобы в дальнейшем было удобней ее получать.
-Также вы можете написать описание для вашей ссылки написав ее после тега или с помощью "#desc:".`
+Вы также можете пометить ссылку одним или несколькими тегами, чтобы в дальнейшем было удобней ее получать.
+Также вы можете написать описание для вашей ссылки написав ее после тегов или с помощью "#desc:".`
 
 const msgHelpCmdEn = `In all examples, insert your own data without brackets.
 
-Send the link like this: [Your link] [Your tag] (optional) [Your description] (optional)
+Send the link like this: [Your link] #[Your tag] #[Another tag] (optional) [Your description] (optional)
 Or like this: [Your link] #desc: [Your description]
+You can also send or forward me a message with several links, every one of them will be saved.
+Put each link on a line of its own to give it its own tags and description.
+To move your links here, send me a file with them: bookmarks exported from a browser, an export from Pocket or Raindrop.io, or a file from /export. Folders become tags.
 
 /rnd - sends a random link from the saved ones.
+Use the buttons under it to mark it as read, keep it, get the next one or delete it.
 
 /tag Sends all links contained in the specified tag. 
 Send it like this: /tag [Your tag]
 
 /rndtag sends a random link from the saved ones related to the specified tag. 
-Send it like this: /rndtag [Your tag]`
+Send it like this: /rndtag [Your tag]
+
+/tags - sends all your tags with the number of links in each.
+
+/list - sends all your unread links page by page, with the number of each one in brackets. 
+Send it like this: /list [Your tag] (optional)
+
+/search finds your links by words from their address, description or tags, read ones included. 
+Send it like this: /search [Your query]
+
+/edit changes the tags or the description of a link, the ones you leave out stay as they are. 
+Send it like this: /edit [Number from /list or your link] tag=[Your tags separated by commas] desc=[Your description]
+
+/del deletes a link. 
+Send it like this: /del [Number from /list or your link]
+
+/export sends all your links as a file: json (the default), csv, md (Markdown) or html (browser bookmarks). 
+Send it like this: /export [Format] (optional)
+
+/history - sends the links you have read recently.
+
+/restore puts a read link back to the unread ones. 
+Send it like this: /restore [Number from /history or your link]`
 
 const msgHelpCmdRu = `Во всех примерах вставлять свои данные без скобок.
 
-Присылать ссылку вот так: [Ваша ссылка] [Ваш тег](не обязательно) [Ваше описание](не обязательно)
+Присылать ссылку вот так: [Ваша ссылка] #[Ваш тег] #[Еще тег](не обязательно) [Ваше описание](не обязательно)
 Либо вот так: [Ваша ссылка] #desc: [Ваше описание]
+Также можно прислать или переслать сообщение с несколькими ссылками, сохранится каждая из них.
+Чтобы задать ссылке свои теги и описание, пишите каждую ссылку с новой строки.
+Чтобы перенести сюда свои ссылки, пришлите файл с ними: закладки, выгруженные из браузера, выгрузку из Pocket или Raindrop.io или файл из /export. Папки станут тегами.
 
 /rnd - отправляет случайную ссылки из сохраненных.
+Кнопками под ней можно отметить ее прочитанной, оставить, получить следующую или удалить.
 
 /tag присылает все ссылки лежащие в данном теге. 
 Присылать вот так: /tag [Ваш тег]
 
 /rndtag присылает случайную ссылку из сохраненных, относящуюся к данному тегу. 
-Присылать вот так: /rndtag [Ваш тег]`
+Присылать вот так: /rndtag [Ваш тег]
+
+/tags - присылает все ваши теги с количеством ссылок в каждом.
+
+/list - присылает все непрочитанные ссылки по страницам, с номером каждой в скобках. 
+Присылать вот так: /list [Ваш тег](не обязательно)
+
+/search ищет ваши ссылки по словам из адреса, описания или тегов, включая прочитанные. 
+Присылать вот так: /search [Ваш запрос]
+
+/edit меняет теги или описание ссылки, не указанные остаются как есть. 
+Присылать вот так: /edit [Номер из /list или ваша ссылка] tag=[Ваши теги через запятую] desc=[Ваше описание]
+
+/del удаляет ссылку. 
+Присылать вот так: /del [Номер из /list или ваша ссылка]
+
+/export присылает все ваши ссылки файлом: json (по умолчанию), csv, md (Markdown) или html (закладки браузера). 
+Присылать вот так: /export [Формат](не обязательно)
+
+/history - присылает недавно прочитанные ссылки.
+
+/restore возвращает прочитанную ссылку в непрочитанные. 
+Присылать вот так: /restore [Номер из /history или ваша ссылка]`
 
 const msgHelloEn = "Hi there! 👋😃 \n\n" + msgHelpEn + "\nYou can use /help_en on english or /help_ru on russian for help"
 
 const (
-	msgUnknownCommand = "Unknown command 🤨"
-	msgNoSavedPages   = "You have no saved pages 😎"
-	msgSaved          = "Saved! 🫡"
-	msgAlreadyExists  = "You have already have this page in your list 🤫"
-	msgTagIsEmpty     = "This tag is empty 😅"
-	msgWrongTagCmd    = "You need to send it like this: /tag [Your tag] 🤓"
-	msgWrongRndTagCmd = "You need to send it like this: /rndtag [Your tag] 🤓"
+	msgUnknownCommand  = "Unknown command 🤨"
+	msgNoSavedPages    = "You have no saved pages 😎"
+	msgSaved           = "Saved! 🫡"
+	msgAlreadyExists   = "You have already have this page in your list 🤫"
+	msgTagIsEmpty      = "This tag is empty 😅"
+	msgWrongTagCmd     = "You need to send it like this: /tag [Your tag] 🤓"
+	msgWrongRndTagCmd  = "You need to send it like this: /rndtag [Your tag] 🤓"
+	msgMarkedRead      = "Marked as read 📚"
+	msgKept            = "Kept for later 📌"
+	msgDeleted         = "Deleted 🗑"
+	msgUnknownAction   = "Unknown action 🤨"
+	msgNoHistory       = "You haven't read anything yet 📭"
+	msgHistoryHeader   = "Recently read:\n\n"
+	msgRestored        = "Restored! It's back in your list 🔄"
+	msgPageNotFound    = "I can't find this page 🤷"
+	msgWrongRestoreCmd = "You need to send it like this: /restore [Number from /history or your link] 🤓"
+	msgNoTags          = "You have no tags yet 🏷"
+	msgTagsHeader      = "Your tags:\n\n"
+	msgNothingFound    = "Nothing found 🔍"
+	msgWrongSearchCmd  = "You need to send it like this: /search [Your query] 🤓"
+	msgListAll         = "Your pages"
+	msgListChanged     = "Your list has changed, back to the start 🔄"
+	msgSavedLinks      = "Saved: %d, already in your list: %d 🫡\n\n"
+	msgLinkExists      = " (already in your list)"
+	msgNoLinks         = "I found no links in this message 🤷"
+	msgWrongEditCmd    = "You need to send it like this: /edit [Number from /list or your link] tag=[Your tags separated by commas] desc=[Your description] 🤓"
+	msgWrongExportCmd  = "You need to send it like this: /export [json, csv, md or html] 🤓"
+	msgExported        = "Your %d pages 📦"
+	msgImportStarted   = "Importing %d links from %s ⏳"
+	msgImportProgress  = "Importing: %d of %d links done ⏳"
+	msgImported        = "Imported from %s 📥\n\nSaved: %d\nAlready in your list: %d\nSkipped, not web pages: %d"
+	msgImportStopped   = "Import stopped after %d of %d links, the bot is restarting. Send the file again to import the rest 🔄"
+	msgImportFailed    = "I couldn't import this file 😢 Send it again to try once more"
+	msgImportFailedAt  = "Import failed after %d of %d links 😢 Send the file again to import the rest"
+	msgImportUnknown   = "I can't read this file 🤷 Send me bookmarks exported from a browser, an export from Pocket or Raindrop.io, or a file from /export"
+	msgImportTooLarge  = "This file is too large, I can only import files up to 20 MB 😥"
+	msgWrongDelCmd     = "You need to send it like this: /del [Number from /list or your link] 🤓"
+	msgEditHint        = "To change this page, send:\n/edit %d tag=[Your tags separated by commas] desc=[Your description]\n\nTo delete it, send:\n/del %d"
 )
+
+const (
+	btnMarkRead = "✅ Mark read"
+	btnKeep     = "📌 Keep"
+	btnNext     = "➡️ Next"
+	btnDelete   = "🗑 Delete"
+	btnEdit     = "✏️ Edit"
+	btnPrev     = "⬅️ Prev"
+)
+
+// searchHeader starts the first line of search results, followed by the query
+const searchHeader = "🔎 "
+
+// listHeader starts the first line of /list, followed by the tag or msgListAll
+const listHeader = "📋 "
@@ -0,0 +1,178 @@
+package telegram
+
+import (
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
+)
+
+const (
+	// maxMessageLen is the longest text Telegram accepts in a message, counted in UTF-16 code units
+	maxMessageLen = 4096
+
+	// messagePartInterval is the pause between the parts of a split message. Telegram allows about one
+	// message per second in a chat, going faster for long gets the bot flood-limited
+	messagePartInterval = time.Second
+)
+
+// sendEntries sends the header followed by the entries joined with sep, split into as many
+// messages as needed to fit the length limit. Messages are split between entries only,
+// unless a single entry doesn't fit in a message of its own. The parts are sent in order,
+// stopping at the first one that fails
+func (p *Processor) sendEntries(chatID int, header string, entries []string, sep string) error {
+	for i, part := range splitMessage(header, entries, sep, maxMessageLen) {
+		if i > 0 && p.partInterval > 0 {
+			time.Sleep(p.partInterval)
+		}
+
+		if err := p.tg.SendMessage(chatID, part); err != nil {
+			return e.Wrap("can't send message part", err)
+		}
+	}
+
+	return nil
+}
+
+// splitMessage joins the header and the entries with sep into messages of at most limit
+// UTF-16 code units, starting a new message only on an entry boundary. The header opens
+// the first message, an entry that doesn't fit in a message of its own is cut with cutText
+func splitMessage(header string, entries []string, sep string, limit int) []string {
+	var parts []string
+
+	var part strings.Builder
+	part.WriteString(header)
+	size := textLen(header)
+	// empty tells whether the part has no entries yet, so no separator is due before the next one
+	empty := true
+
+	flush := func() {
+		if part.Len() > 0 {
+			parts = append(parts, strings.TrimRight(part.String(), " \n"))
+		}
+		part.Reset()
+		size, empty = 0, true
+	}
+
+	for _, entry := range entries {
+		n := textLen(entry)
+		if !empty {
+			n += textLen(sep)
+		}
+
+		if !empty && size+n > limit {
+			flush()
+			n = textLen(entry)
+		}
+
+		if size+n > limit {
+			// The entry doesn't fit even in a part of its own, so its pieces fill whole parts
+			chunks := cutText(entry, limit-size)
+			part.WriteString(chunks[0])
+			flush()
+
+			last := len(chunks) - 1
+			parts = append(parts, chunks[1:last]...)
+			entry, n = chunks[last], textLen(chunks[last])
+		}
+
+		if !empty {
+			part.WriteString(sep)
+		}
+		part.WriteString(entry)
+		size += n
+		empty = false
+	}
+
+	flush()
+
+	return parts
+}
+
+// joinEntries joins the header and the entries with sep into a single message of at most
+// limit UTF-16 code units. Every entry gets an even share of the room the previous ones left,
+// the ones longer than their share are cut and end with "…"
+func joinEntries(header string, entries []string, sep string, limit int) string {
+	var b strings.Builder
+	b.WriteString(header)
+
+	room := limit - textLen(header) - textLen(sep)*max(len(entries)-1, 0)
+
+	for i, entry := range entries {
+		if share := room / (len(entries) - i); textLen(entry) > share {
+			entry = shorten(entry, max(share-1, 1)) + "…"
+		}
+
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(entry)
+
+		room -= textLen(entry)
+	}
+
+	return b.String()
+}
+
+// shorten returns the beginning of text at most limit UTF-16 code units long, cut at a space
+// or line break if there is one in its second half
+func shorten(text string, limit int) string {
+	end, size := len(text), 0
+	for i, r := range text {
+		n := utf16.RuneLen(r)
+		if size+n > limit {
+			end = i
+			break
+		}
+		size += n
+	}
+
+	if cut := strings.LastIndexAny(text[:end], " \n"); cut > end/2 {
+		end = cut
+	}
+
+	return strings.TrimRight(text[:end], " \n")
+}
+
+// cutText cuts text into pieces of at most limit UTF-16 code units, preferring to cut at a
+// line break, then at a space, so URLs and words are kept whole whenever they fit
+func cutText(text string, limit int) []string {
+	var res []string
+
+	for textLen(text) > limit {
+		// end is the byte offset of the longest prefix that fits
+		end, size := 0, 0
+		for i, r := range text {
+			n := utf16.RuneLen(r)
+			if size+n > limit {
+				end = i
+				break
+			}
+			size += n
+		}
+
+		cut := strings.LastIndexByte(text[:end], '\n')
+		if cut <= 0 {
+			cut = strings.LastIndexByte(text[:end], ' ')
+		}
+		if cut <= 0 {
+			cut = end
+		}
+
+		res = append(res, strings.TrimRight(text[:cut], " \n"))
+		text = strings.TrimLeft(text[cut:], " \n")
+	}
+
+	return append(res, text)
+}
+
+// textLen returns the length of text the way Telegram counts it, in UTF-16 code units
+func textLen(text string) int {
+	n := 0
+	for _, r := range text {
+		n += utf16.RuneLen(r)
+	}
+
+	return n
+}
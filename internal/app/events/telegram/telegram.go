@@ -1,7 +1,12 @@
 package telegram
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"log"
+	"sync"
+	"time"
 
 	"github.com/Braendie/Telegram-bot/internal/app/clients/telegram"
 	"github.com/Braendie/Telegram-bot/internal/app/events"
@@ -11,15 +16,85 @@ import (
 
 // Processor handles event processing from the Telegram client and manages interactions with the storage
 type Processor struct {
-	tg      *telegram.Client
-	offset  int
-	storage storage.Storage
+	tg       *telegram.Client
+	storage  storage.Storage
+	offsets  storage.OffsetStorage
+	enricher Enricher
+
+	mu sync.Mutex
+	// offset is the next update ID to fetch, processed is the next update ID that
+	// has not been handled yet and therefore must not be committed
+	offset       int
+	processed    int
+	offsetLoaded bool
+	// pending holds the fetched updates in fetch order until they are handled,
+	// so the committed offset never passes an update that is still being handled
+	pending []*pendingUpdate
+	// handled is signalled by markProcessed every time an update has been handled
+	handled chan struct{}
+
+	// offsetMu serializes the writes of the offset to storage, which happen outside mu.
+	// persisted is the last offset written
+	offsetMu  sync.Mutex
+	persisted int
+
+	// partInterval is the pause between the parts of a message too long to be sent at once
+	partInterval time.Duration
+
+	// linkedUsers holds the IDs of users whose pages have already been linked by username
+	linkedUsers sync.Map
+
+	// imports tracks the imports running in the background. Close closes importsStopped to
+	// stop them between batches and cancels importsCtx, which their requests are made with,
+	// once its deadline passes. importedUpdates holds the update of the last import started
+	// in every chat
+	imports         sync.WaitGroup
+	importsStopped  chan struct{}
+	importsCtx      context.Context
+	cancelImports   context.CancelFunc
+	importMu        sync.Mutex
+	importsClosed   bool
+	importedUpdates map[int]int
+}
+
+// Enricher fetches the metadata of saved pages in the background
+type Enricher interface {
+	Enqueue(p *storage.Page) bool
+}
+
+const (
+	// maxUpdateAttempts is the number of times an update is processed before it is given up on
+	maxUpdateAttempts = 3
+
+	// inFlightPollInterval is the longest Fetch waits for an update to be handled when Telegram
+	// returns only updates that are still in flight, rather than asking for them again at once
+	inFlightPollInterval = 500 * time.Millisecond
+)
+
+// pendingUpdate tracks the processing state of a fetched update. An update that failed
+// is fetched again while retry is set
+type pendingUpdate struct {
+	id       int
+	attempts int
+	done     bool
+	retry    bool
 }
 
-// Meta contains metadata about a Telegram message, including chat ID and username
+// Meta contains metadata about a Telegram update, including the update ID, chat ID and the sender.
+// For messages it holds the links marked in the text, whether the message was forwarded and
+// the document sent with it, if any.
+// For callback queries it also identifies the pressed button and the message it belongs to
 type Meta struct {
-	ChatID   int
-	UserName string
+	UpdateID    int
+	ChatID      int
+	UserID      int
+	UserName    string
+	MessageID   int
+	Links       []string
+	Forwarded   bool
+	Document    *telegram.Document
+	CallbackID  string
+	MessageText string
 }
 
 var (
@@ -27,17 +102,66 @@ var (
 	ErrUnknownMetaType = errors.New("unknown meta type")
 )
 
-// New creates and returns a new Processor with the provided Telegram client and storage
-func New(client *telegram.Client, storage storage.Storage) *Processor {
+// New creates and returns a new Processor with the provided Telegram client, page storage,
+// the storage the update offset is persisted in and the enricher saved pages are passed to,
+// which may be nil to keep pages without metadata
+func New(client *telegram.Client, storage storage.Storage, offsets storage.OffsetStorage, enricher Enricher) *Processor {
+	importsCtx, cancelImports := context.WithCancel(context.Background())
+
 	return &Processor{
-		tg:      client,
-		storage: storage,
+		tg:       client,
+		storage:  storage,
+		offsets:  offsets,
+		enricher: enricher,
+
+		handled: make(chan struct{}, 1),
+
+		partInterval: messagePartInterval,
+
+		importsStopped:  make(chan struct{}),
+		importsCtx:      importsCtx,
+		cancelImports:   cancelImports,
+		importedUpdates: make(map[int]int),
 	}
 }
 
-// Fetch retrieves a batch of events from the Telegram API and returns them as an array of Event structs
-func (p *Processor) Fetch(limit int) ([]events.Event, error) {
-	updates, err := p.tg.Updates(p.offset, limit)
+// Close stops the imports running in the background after the batch being saved and waits
+// until they have stopped or ctx is done. Then the requests still running are cancelled,
+// Close returns once the imports have stopped. No import is started afterwards
+func (p *Processor) Close(ctx context.Context) {
+	p.importMu.Lock()
+	if !p.importsClosed {
+		p.importsClosed = true
+		close(p.importsStopped)
+	}
+	p.importMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.imports.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		p.cancelImports()
+		<-done
+	}
+
+	p.cancelImports()
+}
+
+// Fetch retrieves a batch of events from the Telegram API and returns them as an array of Event structs.
+// The first call resumes from the offset persisted by a previous run. Updates already fetched
+// are returned again only when their processing failed, see markProcessed
+func (p *Processor) Fetch(ctx context.Context, limit int) ([]events.Event, error) {
+	offset, err := p.fetchOffset()
+	if err != nil {
+		return nil, e.Wrap("can't get events", err)
+	}
+
+	updates, err := p.tg.Updates(ctx, offset, limit)
 	if err != nil {
 		return nil, e.Wrap("can't get events", err)
 	}
@@ -45,41 +169,291 @@ func (p *Processor) Fetch(limit int) ([]events.Event, error) {
 	if len(updates) == 0 {
 		return nil, nil
 	}
+
 	res := make([]events.Event, 0, len(updates))
 
+	p.mu.Lock()
 	for _, u := range updates {
+		if u.ID < p.offset {
+			if p.takeRetry(u.ID) {
+				res = append(res, event(u))
+			}
+
+			continue
+		}
+
 		res = append(res, event(u))
+		p.pending = append(p.pending, &pendingUpdate{id: u.ID})
+		p.offset = u.ID + 1
 	}
+	p.mu.Unlock()
 
-	p.offset = updates[len(updates)-1].ID + 1
+	if len(res) == 0 {
+		p.waitHandled(ctx)
+	}
 
 	return res, nil
 }
 
-// Process takes an Event and processes it based on its type
+// waitHandled waits until an update is handled, ctx is cancelled or inFlightPollInterval
+// passes. Telegram keeps returning the updates in flight without waiting, so Fetch would
+// otherwise ask for them over and over while a slow update is being handled
+func (p *Processor) waitHandled(ctx context.Context) {
+	select {
+	case <-p.handled:
+	case <-ctx.Done():
+	case <-time.After(inFlightPollInterval):
+	}
+}
+
+// takeRetry reports whether the fetched update with the given ID failed and is due to be
+// processed again. It must be called with mu held
+func (p *Processor) takeRetry(id int) bool {
+	for _, u := range p.pending {
+		if u.id == id && u.retry {
+			u.retry = false
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// fetchOffset returns the update ID to fetch from, loading the persisted offset on first use.
+// Telegram forgets every update below the offset it is asked for, so it is the committed
+// offset: the updates still pending are fetched again, and Fetch drops the ones not due for a retry
+func (p *Processor) fetchOffset() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.offsetLoaded {
+		return p.processed, nil
+	}
+
+	offset, err := p.offsets.Offset()
+	if err != nil {
+		return 0, e.Wrap("can't load offset", err)
+	}
+
+	p.offset = offset
+	p.processed = offset
+	p.offsetLoaded = true
+
+	return offset, nil
+}
+
+// Commit confirms every update whose offset has been persisted to Telegram, so they are not
+// fetched again after a restart
+func (p *Processor) Commit(ctx context.Context) error {
+	p.offsetMu.Lock()
+	persisted := p.persisted
+	p.offsetMu.Unlock()
+
+	if persisted == 0 {
+		return nil
+	}
+
+	if err := p.tg.ConfirmUpdates(ctx, persisted); err != nil {
+		return e.Wrap("can't commit events", err)
+	}
+
+	return nil
+}
+
+// Parse decodes a single Telegram Update, as delivered to a webhook, and converts it into an Event
+func (p *Processor) Parse(data []byte) (events.Event, error) {
+	var upd telegram.Update
+
+	if err := json.Unmarshal(data, &upd); err != nil {
+		return events.Event{}, e.Wrap("can't parse update", err)
+	}
+
+	return event(upd), nil
+}
+
+// Process takes an Event and processes it based on its type. The update offset is
+// committed only once the event has been processed successfully
 func (p *Processor) Process(event events.Event) error {
+	var err error
+
 	switch event.Type {
 	case events.Message:
-		return p.processMessage(event)
+		err = p.processMessage(event)
+	case events.Callback:
+		err = p.processCallback(event)
 	default:
-		return e.Wrap("can't process message", ErrUnknownEvent)
+		err = e.Wrap("can't process message", ErrUnknownEvent)
+	}
+
+	if commitErr := p.markProcessed(event, err == nil); commitErr != nil && err == nil {
+		return commitErr
 	}
+
+	return err
 }
 
-// processMessage handles a message event, retrieving metadata and executing commands
+// processMessage handles a message event, retrieving metadata and executing commands.
+// Messages holding several links, links hidden behind text or forwarded links are saved
+// at once, see linkPages
 func (p *Processor) processMessage(event events.Event) error {
 	meta, err := meta(event)
 	if err != nil {
 		return e.Wrap("can't process message", err)
 	}
 
-	if err := p.doCmd(event.Text, meta.ChatID, meta.UserName); err != nil {
+	if err := p.linkUser(meta); err != nil {
+		return e.Wrap("can't process message", err)
+	}
+
+	if meta.Document != nil {
+		p.startImport(meta)
+
+		return nil
+	}
+
+	if pages := linkPages(event.Text, meta); pages != nil {
+		if err := p.savePages(meta.ChatID, pages); err != nil {
+			return e.Wrap("can't process message", err)
+		}
+
+		return nil
+	}
+
+	// A forwarded message was written by someone else, so it never runs a command
+	if meta.Forwarded {
+		if err := p.tg.SendMessage(meta.ChatID, msgNoLinks); err != nil {
+			return e.Wrap("can't process message", err)
+		}
+
+		return nil
+	}
+
+	if err := p.doCmd(event.Text, meta.ChatID, meta.UserID, meta.UserName); err != nil {
 		return e.Wrap("can't process messsage", err)
 	}
 
 	return nil
 }
 
+// processCallback handles a press of an inline keyboard button
+func (p *Processor) processCallback(event events.Event) error {
+	meta, err := meta(event)
+	if err != nil {
+		return e.Wrap("can't process callback", err)
+	}
+
+	if err := p.linkUser(meta); err != nil {
+		return e.Wrap("can't process callback", err)
+	}
+
+	if err := p.doCallback(event.Text, meta); err != nil {
+		return e.Wrap("can't process callback", err)
+	}
+
+	return nil
+}
+
+// linkUser attaches the pages the sender saved by username before user IDs were stored
+// to their ID. It runs once per user for the lifetime of the processor
+func (p *Processor) linkUser(meta Meta) error {
+	if _, linked := p.linkedUsers.Load(meta.UserID); linked {
+		return nil
+	}
+
+	if err := p.storage.LinkUser(meta.UserID, meta.UserName); err != nil {
+		return e.Wrap("can't link user", err)
+	}
+
+	p.linkedUsers.Store(meta.UserID, struct{}{})
+
+	return nil
+}
+
+// markProcessed records that the update behind the event has been handled and persists
+// the new offset, so the update is not delivered again after a restart. Updates finish
+// out of order when processed in parallel, so the offset only moves past the oldest
+// pending update once it is done. An update that failed stops the offset and is fetched
+// again, up to maxUpdateAttempts times. After that it is logged and dropped, so a single
+// broken update doesn't hold up the ones after it forever
+func (p *Processor) markProcessed(event events.Event, ok bool) error {
+	meta, err := meta(event)
+	if err != nil {
+		return e.Wrap("can't commit event", err)
+	}
+
+	p.mu.Lock()
+
+	committed := p.processed
+	tracked := false
+
+	for _, u := range p.pending {
+		if u.id != meta.UpdateID {
+			continue
+		}
+
+		tracked = true
+		u.attempts++
+
+		switch {
+		case ok:
+			u.done = true
+		case u.attempts < maxUpdateAttempts:
+			u.retry = true
+		default:
+			log.Printf("[ERR] dropping update %d after %d failed attempts", u.id, u.attempts)
+			u.done = true
+		}
+
+		break
+	}
+
+	switch {
+	case tracked:
+		for len(p.pending) > 0 && p.pending[0].done {
+			committed = p.pending[0].id + 1
+			p.pending = p.pending[1:]
+		}
+	case ok:
+		// Updates pushed to a webhook are never fetched, so they are committed as they come
+		committed = max(committed, meta.UpdateID+1)
+	}
+
+	p.processed = max(p.processed, committed)
+
+	p.mu.Unlock()
+
+	select {
+	case p.handled <- struct{}{}:
+	default:
+	}
+
+	return p.persistOffset()
+}
+
+// persistOffset writes the committed offset to storage. It runs outside mu, so a slow
+// storage holds up neither fetching nor the other workers
+func (p *Processor) persistOffset() error {
+	p.offsetMu.Lock()
+	defer p.offsetMu.Unlock()
+
+	p.mu.Lock()
+	committed := p.processed
+	p.mu.Unlock()
+
+	if committed <= p.persisted {
+		return nil
+	}
+
+	if err := p.offsets.SetOffset(committed); err != nil {
+		return e.Wrap("can't commit event", err)
+	}
+
+	p.persisted = committed
+
+	return nil
+}
+
 // meta extracts and returns metadata from an event, ensuring it matches the expected Meta type
 func meta(event events.Event) (Meta, error) {
 	res, ok := event.Meta.(Meta)
@@ -94,35 +468,62 @@ func meta(event events.Event) (Meta, error) {
 func event(upd telegram.Update) events.Event {
 	updType := fetchType(upd)
 
-	res := events.Event{
-		Type: updType,
-		Text: fetchText(upd),
+	meta := Meta{
+		UpdateID: upd.ID,
 	}
 
-	if updType == events.Message {
-		res.Meta = Meta{
-			ChatID:   upd.Message.Chat.ID,
-			UserName: upd.Message.From.UserName,
+	switch updType {
+	case events.Message:
+		meta.ChatID = upd.Message.Chat.ID
+		meta.UserID = upd.Message.From.ID
+		meta.UserName = upd.Message.From.UserName
+		meta.MessageID = upd.Message.ID
+		meta.Links = messageLinks(upd.Message)
+		meta.Forwarded = upd.Message.ForwardOrigin != nil
+		meta.Document = upd.Message.Document
+	case events.Callback:
+		meta.UserID = upd.CallbackQuery.From.ID
+		meta.UserName = upd.CallbackQuery.From.UserName
+		meta.CallbackID = upd.CallbackQuery.ID
+		if upd.CallbackQuery.Message != nil {
+			meta.ChatID = upd.CallbackQuery.Message.Chat.ID
+			meta.MessageID = upd.CallbackQuery.Message.ID
+			meta.MessageText = upd.CallbackQuery.Message.Text
 		}
 	}
 
-	return res
+	return events.Event{
+		Type: updType,
+		Text: fetchText(upd),
+		Key:  meta.ChatID,
+		Meta: meta,
+	}
 }
 
 // fetchType determines the event type based on the contents of the Telegram Update
 func fetchType(upd telegram.Update) events.Type {
-	if upd.Message == nil {
-		return events.Unknown
+	switch {
+	case upd.Message != nil:
+		return events.Message
+	case upd.CallbackQuery != nil:
+		return events.Callback
 	}
 
-	return events.Message
+	return events.Unknown
 }
 
-// fetchText retrieves the text content from the Telegram Update, if available
+// fetchText retrieves the text content from the Telegram Update, if available. For media
+// messages this is the caption, for callback queries the callback data of the pressed button
 func fetchText(upd telegram.Update) string {
-	if upd.Message == nil {
-		return ""
+	switch {
+	case upd.Message != nil:
+		if upd.Message.Text == "" {
+			return upd.Message.Caption
+		}
+		return upd.Message.Text
+	case upd.CallbackQuery != nil:
+		return upd.CallbackQuery.Data
 	}
 
-	return upd.Message.Text
+	return ""
 }
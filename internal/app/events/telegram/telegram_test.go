@@ -0,0 +1,949 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Braendie/Telegram-bot/internal/app/clients/telegram"
+	"github.com/Braendie/Telegram-bot/internal/app/clients/telegram/telegramtest"
+	"github.com/Braendie/Telegram-bot/internal/app/enricher"
+	"github.com/Braendie/Telegram-bot/internal/app/importer"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/memory"
+)
+
+const (
+	testUserID   = 42
+	testUserName = "alice"
+)
+
+// bot wires a processor to a fake Bot API and an in-memory storage
+type bot struct {
+	t       *testing.T
+	api     *telegramtest.Server
+	storage *memory.Storage
+	p       *Processor
+}
+
+func newBot(t *testing.T) *bot {
+	api := telegramtest.NewServer(t)
+	s := memory.New()
+
+	p := New(api.Client(), s, s, nil)
+	p.partInterval = 0
+	t.Cleanup(func() { p.Close(context.Background()) })
+
+	return &bot{
+		t:       t,
+		api:     api,
+		storage: s,
+		p:       p,
+	}
+}
+
+// send delivers a message from the test user and returns the bot's replies to it
+func (b *bot) send(text string) []telegramtest.Message {
+	b.t.Helper()
+
+	b.api.AddMessage(testUserID, testUserName, text)
+
+	return b.run()
+}
+
+// press presses the button with the callback data under the message and returns the bot's replies
+func (b *bot) press(message telegramtest.Message, data string) []telegramtest.Message {
+	b.t.Helper()
+
+	b.api.AddCallback(testUserID, testUserName, message, data)
+
+	return b.run()
+}
+
+// run fetches the queued updates, processes them and returns the messages sent meanwhile
+func (b *bot) run() []telegramtest.Message {
+	b.t.Helper()
+
+	sent := len(b.api.Sent())
+
+	if err := b.process(); err != nil {
+		b.t.Fatalf("process: %v", err)
+	}
+
+	return b.api.Sent()[sent:]
+}
+
+func (b *bot) process() error {
+	events, err := b.p.Fetch(context.Background(), 100)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		if err := b.p.Process(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reply checks that the bot answered with exactly one message and returns it
+func reply(t *testing.T, sent []telegramtest.Message) telegramtest.Message {
+	t.Helper()
+
+	if len(sent) != 1 {
+		t.Fatalf("got %d replies %+v, want 1", len(sent), sent)
+	}
+
+	if sent[0].ChatID != testUserID {
+		t.Errorf("reply sent to chat %d, want %d", sent[0].ChatID, testUserID)
+	}
+
+	return sent[0]
+}
+
+func TestSavePage(t *testing.T) {
+	b := newBot(t)
+
+	if got := reply(t, b.send("https://example.com/a go")).Text; got != msgSaved {
+		t.Errorf("got reply %q, want %q", got, msgSaved)
+	}
+
+	for _, link := range []string{"https://example.com/a", "example.com/a/", "https://Example.com/a?utm_source=x#top"} {
+		if got := reply(t, b.send(link)).Text; got != msgAlreadyExists {
+			t.Errorf("got reply %q to %s, want %q", got, link, msgAlreadyExists)
+		}
+	}
+
+	page, err := b.storage.PickRandom(testUserID)
+	if err != nil {
+		t.Fatalf("PickRandom: %v", err)
+	}
+
+	if page.URL != "https://example.com/a" || !slices.Equal(page.Tags, []string{"go"}) || page.UserName != testUserName {
+		t.Errorf("got saved page %+v", page)
+	}
+}
+
+// sendIncoming delivers a message with entities, a caption or a forward origin from the test
+// user and returns the bot's replies to it
+func (b *bot) sendIncoming(msg telegram.IncomingMessage) []telegramtest.Message {
+	b.t.Helper()
+
+	b.api.AddIncoming(testUserID, testUserName, msg)
+
+	return b.run()
+}
+
+func TestSaveMessageLinks(t *testing.T) {
+	b := newBot(t)
+
+	b.send("https://example.com/a")
+
+	// Entity offsets are counted in UTF-16 code units, the emoji takes two
+	got := reply(t, b.sendIncoming(telegram.IncomingMessage{
+		Text: "📌 see https://example.com/a and here",
+		Entities: []telegram.MessageEntity{
+			{Type: telegram.EntityURL, Offset: 7, Length: 21},
+			{Type: "bold", Offset: 3, Length: 3},
+			{Type: telegram.EntityTextLink, Offset: 33, Length: 4, URL: "https://example.com/b?utm_source=x"},
+			// Only web pages are saved
+			{Type: telegram.EntityTextLink, Offset: 0, Length: 2, URL: "tg://resolve?domain=example"},
+			{Type: telegram.EntityTextLink, Offset: 0, Length: 2, URL: "mailto:me@example.com"},
+			{Type: telegram.EntityTextLink, Offset: 0, Length: 2, URL: "javascript:alert(1)"},
+		},
+	})).Text
+
+	want := fmt.Sprintf(msgSavedLinks, 1, 1) + "https://example.com/a" + msgLinkExists + "\nhttps://example.com/b"
+	if got != want {
+		t.Errorf("got reply %q, want %q", got, want)
+	}
+
+	// A forwarded post is saved link by link, its text is not taken for tags
+	got = reply(t, b.sendIncoming(telegram.IncomingMessage{
+		Caption:         "https://example.com/c #go",
+		CaptionEntities: []telegram.MessageEntity{{Type: telegram.EntityURL, Offset: 0, Length: 21}},
+		ForwardOrigin: &telegram.MessageOrigin{
+			Type: "channel",
+			Chat: &telegram.Chat{ID: -100, Title: "Links"},
+		},
+	})).Text
+
+	if want := fmt.Sprintf(msgSavedLinks, 1, 0) + "https://example.com/c"; got != want {
+		t.Errorf("got reply %q, want %q", got, want)
+	}
+
+	if got := reply(t, b.send("/tags")).Text; got != msgNoTags {
+		t.Errorf("got reply %q to /tags, want %q", got, msgNoTags)
+	}
+
+	// A single link sent by the user keeps its tags
+	got = reply(t, b.sendIncoming(telegram.IncomingMessage{
+		Text:     "https://example.com/d go",
+		Entities: []telegram.MessageEntity{{Type: telegram.EntityURL, Offset: 0, Length: 21}},
+	})).Text
+
+	if got != msgSaved {
+		t.Errorf("got reply %q, want %q", got, msgSaved)
+	}
+
+	if want := "#go:\n\n1) https://example.com/d"; reply(t, b.send("/tag go")).Text != want {
+		t.Errorf("page with a single link lost its tag")
+	}
+
+	// A forwarded command is not run
+	got = reply(t, b.sendIncoming(telegram.IncomingMessage{
+		Text:          "/del 1",
+		ForwardOrigin: &telegram.MessageOrigin{Type: "user"},
+	})).Text
+
+	if got != msgNoLinks {
+		t.Errorf("got reply %q to a forwarded command, want %q", got, msgNoLinks)
+	}
+
+	if pages, err := b.storage.Pages(testUserID); err != nil || len(pages) != 4 {
+		t.Errorf("got %d pages, %v after a forwarded /del, want 4", len(pages), err)
+	}
+}
+
+func TestBulkSave(t *testing.T) {
+	b := newBot(t)
+
+	b.send("https://example.com/a")
+
+	got := reply(t, b.send(`Links from the chat:
+https://example.com/a/
+https://example.com/b #go #db about databases
+  example.com/c go
+https://example.com/b?utm_source=chat`)).Text
+
+	want := fmt.Sprintf(msgSavedLinks, 2, 1) +
+		"https://example.com/a" + msgLinkExists + "\nhttps://example.com/b\nhttps://example.com/c"
+	if got != want {
+		t.Errorf("got reply %q, want %q", got, want)
+	}
+
+	want = "#go:\n\n1) https://example.com/b\nabout databases\n\n2) https://example.com/c"
+	if got := reply(t, b.send("/tag go")).Text; got != want {
+		t.Errorf("got reply %q, want %q", got, want)
+	}
+
+	// A message with a single link is still answered as before
+	if got := reply(t, b.send("https://example.com/d #go")).Text; got != msgSaved {
+		t.Errorf("got reply %q, want %q", got, msgSaved)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"/start", msgHelloEn},
+		{"/help_en", msgHelpEn + "\n\n" + msgHelpCmdEn},
+		{"/rnd", msgNoSavedPages},
+		{"/tag", msgWrongTagCmd},
+		{"/tag go", msgTagIsEmpty},
+		{"/tags", msgNoTags},
+		{"/search", msgWrongSearchCmd},
+		{"/search go", msgNothingFound},
+		{"/list", msgNoSavedPages},
+		{"/list go", msgTagIsEmpty},
+		{"/history", msgNoHistory},
+		{"/restore", msgWrongRestoreCmd},
+		{"/unknown", msgUnknownCommand},
+		{"hello", "okay."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			b := newBot(t)
+
+			if got := reply(t, b.send(tt.text)).Text; got != tt.want {
+				t.Errorf("got reply %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTag(t *testing.T) {
+	b := newBot(t)
+
+	b.send("https://example.com/a go")
+	b.send("https://example.com/b go second")
+	b.send("https://example.com/c rust")
+
+	want := "#go:\n\n1) https://example.com/a\n\n2) https://example.com/b\nsecond"
+	if got := reply(t, b.send("/tag go")).Text; got != want {
+		t.Errorf("got reply %q, want %q", got, want)
+	}
+}
+
+func TestMultipleTags(t *testing.T) {
+	b := newBot(t)
+
+	b.send("https://example.com/a #go #db a page about databases")
+	b.send("https://example.com/b #go #desc: a page about go")
+
+	want := "#db:\n\n1) https://example.com/a\na page about databases"
+	if got := reply(t, b.send("/tag #db")).Text; got != want {
+		t.Errorf("got reply %q, want %q", got, want)
+	}
+
+	want = "#go:\n\n1) https://example.com/a\na page about databases\n\n2) https://example.com/b\na page about go"
+	if got := reply(t, b.send("/tag go")).Text; got != want {
+		t.Errorf("got reply %q, want %q", got, want)
+	}
+
+	want = msgTagsHeader + "#go - 2\n#db - 1"
+	if got := reply(t, b.send("/tags")).Text; got != want {
+		t.Errorf("got reply %q, want %q", got, want)
+	}
+
+	want = "https://example.com/a\n#db #go\na page about databases"
+	if got := reply(t, b.send("/rndtag db")).Text; got != want {
+		t.Errorf("got reply %q, want %q", got, want)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	b := newBot(t)
+
+	for i := 1; i <= 7; i++ {
+		b.send(fmt.Sprintf("https://example.com/%d #go", i))
+	}
+	b.send("https://example.com/rust #rust")
+
+	results := reply(t, b.send("/search  go"))
+	if !strings.HasPrefix(results.Text, searchHeader+"go\n\n1) ") || !strings.Contains(results.Text, "\n\n5) ") ||
+		strings.Contains(results.Text, "6) ") {
+		t.Fatalf("got first page of results %q", results.Text)
+	}
+
+	if results.ReplyMarkup == nil || len(results.ReplyMarkup.InlineKeyboard[0]) != 1 ||
+		results.ReplyMarkup.InlineKeyboard[0][0].CallbackData != SearchAction+":5" {
+		t.Fatalf("got first page keyboard %+v, want a single next button", results.ReplyMarkup)
+	}
+
+	if sent := b.press(results, SearchAction+":5"); len(sent) != 0 {
+		t.Errorf("got replies %+v to turning the page, want none", sent)
+	}
+
+	edited := b.api.Sent()[len(b.api.Sent())-1]
+	if !strings.HasPrefix(edited.Text, searchHeader+"go\n\n6) ") || !strings.Contains(edited.Text, "\n\n7) ") {
+		t.Errorf("got second page of results %q", edited.Text)
+	}
+
+	if edited.ReplyMarkup == nil || edited.ReplyMarkup.InlineKeyboard[0][0].CallbackData != SearchAction+":0" {
+		t.Errorf("got second page keyboard %+v, want a single prev button", edited.ReplyMarkup)
+	}
+}
+
+func TestList(t *testing.T) {
+	b := newBot(t)
+
+	for i := 1; i <= 12; i++ {
+		b.send(fmt.Sprintf("https://example.com/%d #go", i))
+	}
+	b.send("https://example.com/rust #rust")
+
+	list := reply(t, b.send("/list"))
+	if !strings.HasPrefix(list.Text, listHeader+msgListAll+"\n\n[1] https://example.com/1\n#go") ||
+		!strings.Contains(list.Text, "[10] ") || strings.Contains(list.Text, "[11] ") {
+		t.Fatalf("got first page of the list %q", list.Text)
+	}
+
+	if list.ReplyMarkup == nil || len(list.ReplyMarkup.InlineKeyboard[0]) != 1 ||
+		list.ReplyMarkup.InlineKeyboard[0][0].CallbackData != ListAction+":a10" {
+		t.Fatalf("got first page keyboard %+v, want a single next button", list.ReplyMarkup)
+	}
+
+	if sent := b.press(list, ListAction+":a10"); len(sent) != 0 {
+		t.Errorf("got replies %+v to turning the page, want none", sent)
+	}
+
+	edited := b.api.Sent()[len(b.api.Sent())-1]
+	want := listHeader + msgListAll + "\n\n[11] https://example.com/11\n#go\n\n[12] https://example.com/12\n#go\n\n" +
+		"[13] https://example.com/rust\n#rust"
+	if edited.Text != want {
+		t.Errorf("got second page of the list %q, want %q", edited.Text, want)
+	}
+
+	if edited.ReplyMarkup == nil || len(edited.ReplyMarkup.InlineKeyboard[0]) != 1 ||
+		edited.ReplyMarkup.InlineKeyboard[0][0].CallbackData != ListAction+":b11" {
+		t.Fatalf("got second page keyboard %+v, want a single prev button", edited.ReplyMarkup)
+	}
+
+	b.press(edited, ListAction+":b11")
+
+	edited = b.api.Sent()[len(b.api.Sent())-1]
+	if edited.Text != list.Text {
+		t.Errorf("got %q going back, want the first page %q", edited.Text, list.Text)
+	}
+
+	tagged := reply(t, b.send("/list #rust"))
+	if tagged.Text != listHeader+"#rust\n\n[13] https://example.com/rust\n#rust" || tagged.ReplyMarkup != nil {
+		t.Errorf("got list of a tag %q with keyboard %+v", tagged.Text, tagged.ReplyMarkup)
+	}
+}
+
+func TestListPageDeleted(t *testing.T) {
+	b := newBot(t)
+
+	for i := 1; i <= 11; i++ {
+		b.send(fmt.Sprintf("https://example.com/%d", i))
+	}
+
+	list := reply(t, b.send("/list"))
+	b.send("/del 11")
+
+	// The next page is gone, so the list is shown from the start with the buttons it needs now
+	if sent := b.press(list, ListAction+":a10"); len(sent) != 0 {
+		t.Errorf("got replies %+v to turning the page, want none", sent)
+	}
+
+	// Edits change the sent message in place, the list is followed by the reply to /del
+	edited := b.api.Sent()[len(b.api.Sent())-2]
+	if edited.ID != list.ID {
+		t.Fatalf("got message %d, want the list %d", edited.ID, list.ID)
+	}
+
+	if edited.Text != list.Text || edited.ReplyMarkup != nil {
+		t.Errorf("got %q with keyboard %+v, want the first page %q without one", edited.Text, edited.ReplyMarkup, list.Text)
+	}
+
+	if answers := b.api.Answers(); len(answers) != 1 || answers[0] != msgListChanged {
+		t.Errorf("got callback answers %q, want %q", answers, msgListChanged)
+	}
+}
+
+func TestLongTagIsSplit(t *testing.T) {
+	b := newBot(t)
+
+	// Every emoji takes two UTF-16 code units, so the description is 800 long for Telegram
+	// and only four entries fit in a message
+	description := strings.Repeat("😀", 400)
+	for i := 1; i <= 10; i++ {
+		b.send(fmt.Sprintf("https://example.com/%d go %s", i, description))
+	}
+
+	sent := b.send("/tag go")
+	if len(sent) != 3 {
+		t.Fatalf("got %d messages, want 3", len(sent))
+	}
+
+	var entries []string
+	for _, msg := range sent {
+		if n := textLen(msg.Text); n > maxMessageLen {
+			t.Errorf("got message of %d UTF-16 code units, want at most %d", n, maxMessageLen)
+		}
+		entries = append(entries, strings.Split(msg.Text, "\n\n")...)
+	}
+
+	if entries[0] != "#go:" {
+		t.Errorf("got first line %q, want the tag", entries[0])
+	}
+
+	for i, entry := range entries[1:] {
+		if want := fmt.Sprintf("%d) https://example.com/%d\n%s", i+1, i+1, description); entry != want {
+			t.Errorf("got entry %d %q, want %q", i+1, entry, want)
+		}
+	}
+}
+
+func TestLongListIsCut(t *testing.T) {
+	b := newBot(t)
+
+	description := strings.Repeat("long words ", 300)
+	for i := 1; i <= 11; i++ {
+		b.send(fmt.Sprintf("https://example.com/%d go %s", i, description))
+	}
+
+	list := reply(t, b.send("/list"))
+	results := reply(t, b.send("/search long"))
+
+	for _, msg := range []telegramtest.Message{list, results} {
+		if n := textLen(msg.Text); n > maxMessageLen {
+			t.Errorf("got message of %d UTF-16 code units, want at most %d", n, maxMessageLen)
+		}
+	}
+
+	if !strings.Contains(list.Text, "[10] https://example.com/10\n#go\nlong words") || !strings.HasSuffix(list.Text, "…") {
+		t.Errorf("got list %q, want every entry cut", list.Text)
+	}
+
+	if !strings.Contains(results.Text, "5) https://example.com/") {
+		t.Errorf("got search results %q, want five entries", results.Text)
+	}
+
+	if sent := b.press(list, ListAction+":a10"); len(sent) != 0 {
+		t.Errorf("got replies %+v to turning the page, want none", sent)
+	}
+
+	edited := b.api.Sent()[len(b.api.Sent())-2]
+	if edited.ID != list.ID || !strings.HasPrefix(edited.Text, listHeader+msgListAll+"\n\n[11] https://example.com/11") {
+		t.Errorf("got second page of the list %q", edited.Text)
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		entries []string
+		want    []string
+	}{
+		{"fits", "h\n", []string{"aa", "bb"}, []string{"h\naa\nbb"}},
+		{"on entry boundary", "h:\n", []string{"aaa", "bbb", "ccc"}, []string{"h:\naaa", "bbb\nccc"}},
+		{"utf-16", "", []string{"😀😀", "😀😀", "a"}, []string{"😀😀", "😀😀\na"}},
+		{"long entry at a space", "", []string{"a", "bbbb cccc dd"}, []string{"a", "bbbb", "cccc dd"}},
+		{"long word", "", []string{"aaaaaaaaaaaaaaa"}, []string{"aaaaaaa", "aaaaaaa", "a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitMessage(tt.header, tt.entries, "\n", 7); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageMeta(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<head><title>Go databases</title><meta property="og:site_name" content="Example">
+			<meta name="description" content="All about databases"></head>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	b := newBot(t)
+	en := enricher.New(enricher.NewFetcher(time.Second, 1<<16, true), b.storage, 1, 10)
+	b.p.enricher = en
+
+	b.send(srv.URL + "/a go")
+	// Close waits for the page to be fetched
+	en.Close(context.Background())
+
+	want := srv.URL + "/a\nGo databases — Example\n#go\nAll about databases"
+	if got := reply(t, b.send("/rnd")).Text; got != want {
+		t.Errorf("got random page %q, want %q", got, want)
+	}
+
+	want = "#go:\n\n1) " + srv.URL + "/a\nGo databases — Example"
+	if got := reply(t, b.send("/tag go")).Text; got != want {
+		t.Errorf("got reply %q, want %q", got, want)
+	}
+}
+
+func TestReadAndRestore(t *testing.T) {
+	b := newBot(t)
+
+	b.send("https://example.com/a")
+
+	shown := reply(t, b.send("/rnd"))
+	if shownURL(shown.Text) != "https://example.com/a" {
+		t.Fatalf("got random page %q", shown.Text)
+	}
+	if shown.ReplyMarkup == nil {
+		t.Fatal("random page is sent without a keyboard")
+	}
+
+	if sent := b.press(shown, ReadAction); len(sent) != 0 {
+		t.Errorf("got replies %+v to the read button, want none", sent)
+	}
+
+	if answers := b.api.Answers(); len(answers) != 1 || answers[0] != msgMarkedRead {
+		t.Errorf("got callback answers %q, want %q", answers, msgMarkedRead)
+	}
+
+	if got := reply(t, b.send("/rnd")).Text; got != msgNoSavedPages {
+		t.Errorf("got reply %q after reading the only page, want %q", got, msgNoSavedPages)
+	}
+
+	if got := reply(t, b.send("/history")).Text; !strings.Contains(got, "1) https://example.com/a") {
+		t.Errorf("got history %q without the read page", got)
+	}
+
+	if got := reply(t, b.send("/restore 1")).Text; got != msgRestored {
+		t.Errorf("got reply %q, want %q", got, msgRestored)
+	}
+
+	if got := reply(t, b.send("/rnd")).Text; shownURL(got) != "https://example.com/a" {
+		t.Errorf("got random page %q after restoring", got)
+	}
+}
+
+func TestDeleteButton(t *testing.T) {
+	b := newBot(t)
+
+	b.send("https://example.com/a")
+	shown := reply(t, b.send("/rnd"))
+
+	b.press(shown, DeleteAction)
+	// Pressing the button of an outdated message again must not fail
+	b.press(shown, DeleteAction)
+
+	if answers := b.api.Answers(); len(answers) != 2 || answers[0] != msgDeleted {
+		t.Errorf("got callback answers %q, want %q twice", answers, msgDeleted)
+	}
+
+	if got := reply(t, b.send("/rnd")).Text; got != msgNoSavedPages {
+		t.Errorf("got reply %q after deleting the only page, want %q", got, msgNoSavedPages)
+	}
+}
+
+func TestEditAndDelete(t *testing.T) {
+	b := newBot(t)
+
+	b.send("https://example.com/a #go a page about go")
+	b.send("https://example.com/b #db")
+
+	want := "https://example.com/a\n#go #rust\na page about go"
+	edited := reply(t, b.send("/edit 1 tag=go,#rust"))
+	if edited.Text != want {
+		t.Errorf("got reply %q, want %q", edited.Text, want)
+	}
+
+	if edited.ReplyMarkup == nil || edited.ReplyMarkup.InlineKeyboard[0][0].CallbackData != EditAction {
+		t.Errorf("got keyboard %+v, want the edit button first", edited.ReplyMarkup)
+	}
+
+	want = "https://example.com/a\n#go #rust\nabout go and rust"
+	if got := reply(t, b.send("/edit example.com/a/ desc=about go and rust")).Text; got != want {
+		t.Errorf("got reply %q, want %q", got, want)
+	}
+
+	want = fmt.Sprintf(msgEditHint, 1, 1)
+	if got := reply(t, b.press(edited, EditAction)).Text; got != want {
+		t.Errorf("got reply %q to the edit button, want %q", got, want)
+	}
+
+	for _, text := range []string{"/edit 1", "/edit 1 rust", "/edit 1 go tag=rust"} {
+		if got := reply(t, b.send(text)).Text; got != msgWrongEditCmd {
+			t.Errorf("got reply %q to %q, want %q", got, text, msgWrongEditCmd)
+		}
+	}
+
+	for _, tt := range []struct {
+		text string
+		want string
+	}{
+		{"/edit 7 tag=go", msgPageNotFound},
+		{"/del", msgWrongDelCmd},
+		{"/del 2", msgDeleted},
+		{"/del 2", msgPageNotFound},
+		{"/del https://example.com/a?utm_source=x", msgDeleted},
+	} {
+		if got := reply(t, b.send(tt.text)).Text; got != tt.want {
+			t.Errorf("got reply %q to %q, want %q", got, tt.text, tt.want)
+		}
+	}
+
+	if got := reply(t, b.send("/rnd")).Text; got != msgNoSavedPages {
+		t.Errorf("got reply %q after deleting every page, want %q", got, msgNoSavedPages)
+	}
+}
+
+func TestExport(t *testing.T) {
+	b := newBot(t)
+
+	if got := reply(t, b.send("/export")).Text; got != msgNoSavedPages {
+		t.Errorf("got reply %q, want %q", got, msgNoSavedPages)
+	}
+
+	b.send("https://example.com/a #go about go")
+	b.send("https://example.com/b")
+
+	if sent := b.send("/export csv"); len(sent) != 0 {
+		t.Errorf("got replies %+v, want only the file", sent)
+	}
+
+	docs := b.api.Documents()
+	if len(docs) != 1 {
+		t.Fatalf("got %d documents, want 1", len(docs))
+	}
+
+	if docs[0].ChatID != testUserID || docs[0].FileName != "pages.csv" || docs[0].Caption != fmt.Sprintf(msgExported, 2) {
+		t.Errorf("got document %s with caption %q to chat %d", docs[0].FileName, docs[0].Caption, docs[0].ChatID)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(docs[0].Data)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "https://example.com/a,,go,about go,") {
+		t.Errorf("got export %q", docs[0].Data)
+	}
+
+	if got := reply(t, b.send("/export pdf")).Text; got != msgWrongExportCmd {
+		t.Errorf("got reply %q, want %q", got, msgWrongExportCmd)
+	}
+}
+
+func TestNextButton(t *testing.T) {
+	b := newBot(t)
+
+	b.send("https://example.com/a go")
+	shown := reply(t, b.send("/rndtag go"))
+
+	next := reply(t, b.press(shown, NextAction+":go"))
+	if shownURL(next.Text) != "https://example.com/a" {
+		t.Errorf("got next page %q", next.Text)
+	}
+
+	if calls := b.api.Calls("editMessageReplyMarkup"); len(calls) != 1 {
+		t.Errorf("got %d keyboard edits, want 1", len(calls))
+	}
+}
+
+func TestFloodWait(t *testing.T) {
+	b := newBot(t)
+	b.api.FloodWait("sendMessage", 1)
+
+	if got := reply(t, b.send("/start")).Text; got != msgHelloEn {
+		t.Errorf("got reply %q, want %q", got, msgHelloEn)
+	}
+
+	if calls := b.api.Calls("sendMessage"); len(calls) != 2 {
+		t.Errorf("got %d sendMessage calls, want 2", len(calls))
+	}
+}
+
+func TestFailedReplyIsNotCommitted(t *testing.T) {
+	b := newBot(t)
+	b.api.Fail("sendMessage", http.StatusForbidden, "Forbidden: bot was blocked by the user")
+
+	b.api.AddMessage(testUserID, testUserName, "/start")
+
+	if err := b.process(); !errors.Is(err, telegram.ErrForbidden) {
+		t.Fatalf("got error %v, want %v", err, telegram.ErrForbidden)
+	}
+
+	offset, err := b.storage.Offset()
+	if err != nil {
+		t.Fatalf("Offset: %v", err)
+	}
+
+	if offset != 0 {
+		t.Errorf("got offset %d after a failed update, want 0", offset)
+	}
+
+	// The failed update is fetched again along with the next one
+	sent := b.send("/start")
+	if len(sent) != 2 || sent[0].Text != msgHelloEn || sent[1].Text != msgHelloEn {
+		t.Fatalf("got replies %+v, want two greetings", sent)
+	}
+
+	if offset, _ := b.storage.Offset(); offset != 3 {
+		t.Errorf("got offset %d, want 3", offset)
+	}
+}
+
+func TestFailedUpdateIsDropped(t *testing.T) {
+	b := newBot(t)
+	for range maxUpdateAttempts {
+		b.api.Fail("sendMessage", http.StatusForbidden, "Forbidden: bot was blocked by the user")
+	}
+
+	b.api.AddMessage(testUserID, testUserName, "/start")
+
+	for range maxUpdateAttempts {
+		if err := b.process(); !errors.Is(err, telegram.ErrForbidden) {
+			t.Fatalf("got error %v, want %v", err, telegram.ErrForbidden)
+		}
+	}
+
+	if offset, _ := b.storage.Offset(); offset != 2 {
+		t.Errorf("got offset %d after the update was given up on, want 2", offset)
+	}
+
+	if sent := b.run(); len(sent) != 0 {
+		t.Errorf("got replies %+v to a dropped update", sent)
+	}
+}
+
+// sendDocument delivers a file from the test user and returns the bot's replies to it
+func (b *bot) sendDocument(fileName, data string) []telegramtest.Message {
+	b.t.Helper()
+
+	b.api.AddDocument(testUserID, testUserName, fileName, []byte(data))
+
+	return b.runImport()
+}
+
+// runImport processes the queued updates like run, waiting for the imports they start
+func (b *bot) runImport() []telegramtest.Message {
+	b.t.Helper()
+
+	sent := len(b.api.Sent())
+
+	if err := b.process(); err != nil {
+		b.t.Fatalf("process: %v", err)
+	}
+
+	b.p.imports.Wait()
+
+	return b.api.Sent()[sent:]
+}
+
+func TestImport(t *testing.T) {
+	b := newBot(t)
+
+	b.send("https://example.com/a")
+
+	got := reply(t, b.sendDocument("bookmarks.html", `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+    <DT><A HREF="https://example.com/a">A</A>
+    <DT><H3>Dev</H3>
+    <DL><p>
+        <DT><A HREF="https://example.com/b">Go and databases</A>
+        <DD>About databases
+        <DT><A HREF="https://example.com/b/">B again</A>
+        <DT><A HREF="javascript:void(0)">Bookmarklet</A>
+    </DL><p>
+</DL><p>
+`)).Text
+
+	if want := fmt.Sprintf(msgImported, importer.Netscape, 1, 2, 1); got != want {
+		t.Errorf("got reply %q, want %q", got, want)
+	}
+
+	pages, err := b.storage.PickTag(testUserID, "Dev")
+	if err != nil {
+		t.Fatalf("PickTag: %v", err)
+	}
+
+	if len(pages) != 1 || pages[0].URL != "https://example.com/b" || pages[0].Description.String != "About databases" || pages[0].Meta.Title != "Go and databases" {
+		t.Errorf("got imported pages %+v", pages)
+	}
+
+	got = reply(t, b.sendDocument("pocket.csv", "title,url,time_added,tags,status\nRead,https://example.com/c,1700000000,,archive\n")).Text
+	if want := fmt.Sprintf(msgImported, importer.PocketCSV, 1, 0, 0); got != want {
+		t.Errorf("got reply %q, want %q", got, want)
+	}
+
+	history, err := b.storage.History(testUserID, 10)
+	if err != nil || len(history) != 1 || history[0].URL != "https://example.com/c" {
+		t.Errorf("got history %+v, %v", history, err)
+	}
+
+	if got := reply(t, b.sendDocument("notes.txt", "just some notes")).Text; got != msgImportUnknown {
+		t.Errorf("got reply %q, want %q", got, msgImportUnknown)
+	}
+}
+
+func TestFetchKeepsPendingUpdates(t *testing.T) {
+	b := newBot(t)
+
+	b.api.AddMessage(testUserID, testUserName, "/start")
+
+	first, err := b.p.Fetch(context.Background(), 100)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("Fetch: got %d events, %v", len(first), err)
+	}
+
+	b.api.AddMessage(testUserID, testUserName, "/help")
+
+	second, err := b.p.Fetch(context.Background(), 100)
+	if err != nil || len(second) != 1 || second[0].Text != "/help" {
+		t.Fatalf("Fetch: got events %+v, %v, want only /help", second, err)
+	}
+
+	calls := b.api.Calls("getUpdates")
+	if offset := calls[len(calls)-1].Params.Get("offset"); offset != "0" {
+		t.Errorf("fetched from offset %s while updates were pending, want 0", offset)
+	}
+
+	for _, event := range append(first, second...) {
+		if err := b.p.Process(event); err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+	}
+
+	if _, err := b.p.Fetch(context.Background(), 100); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	calls = b.api.Calls("getUpdates")
+	if offset := calls[len(calls)-1].Params.Get("offset"); offset != "3" {
+		t.Errorf("fetched from offset %s once updates were processed, want 3", offset)
+	}
+}
+
+func TestImportFailed(t *testing.T) {
+	b := newBot(t)
+	b.api.Fail("getFile", http.StatusBadRequest, "Bad Request: invalid file_id")
+
+	if got := reply(t, b.sendDocument("pocket.csv", "title,url,time_added,tags,status\n")).Text; got != msgImportFailed {
+		t.Errorf("got reply %q, want %q", got, msgImportFailed)
+	}
+}
+
+func TestCloseCancelsImport(t *testing.T) {
+	b := newBot(t)
+	release := b.api.Hold("getFile")
+	t.Cleanup(release)
+
+	b.api.AddDocument(testUserID, testUserName, "pocket.csv", []byte("title,url,time_added,tags,status\n"))
+	if err := b.process(); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	closed := make(chan struct{})
+	go func() {
+		b.p.Close(ctx)
+		close(closed)
+	}()
+
+	// The download never ends, so Close cancels it once the deadline has passed
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close didn't return after the deadline")
+	}
+
+	if sent := b.api.Sent(); len(sent) != 0 {
+		t.Errorf("got replies %+v after the import was cancelled", sent)
+	}
+}
+
+func TestImportRedelivered(t *testing.T) {
+	b := newBot(t)
+
+	upd := b.api.AddDocument(testUserID, testUserName, "pocket.csv", []byte("title,url,time_added,tags,status\nA,https://example.com/a,1700000000,,unread\n"))
+	reply(t, b.runImport())
+
+	// A webhook update is delivered again when Telegram doesn't get the response in time
+	data, err := json.Marshal(upd)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	event, err := b.p.Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	sent := len(b.api.Sent())
+	if err := b.p.Process(event); err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	b.p.imports.Wait()
+
+	if again := b.api.Sent()[sent:]; len(again) != 0 {
+		t.Errorf("got replies %+v to a redelivered update, want none", again)
+	}
+
+	if calls := b.api.Calls("getFile"); len(calls) != 1 {
+		t.Errorf("got %d getFile calls, want the file downloaded once", len(calls))
+	}
+}
@@ -1,9 +1,12 @@
 package events
 
+import "context"
+
 // Fetcher defines an interface for fetching events, specifying a Fetch method
-// that retrieves a batch of events up to a specified limit
+// that retrieves a batch of events up to a specified limit, waiting for new ones
+// until they arrive or ctx is cancelled
 type Fetcher interface {
-	Fetch(limit int) ([]Event, error)
+	Fetch(ctx context.Context, limit int) ([]Event, error)
 }
 
 // Processor defines an interface for processing individual events, with a Process
@@ -12,17 +15,32 @@ type Processor interface {
 	Process(e Event) error
 }
 
+// Committer defines an interface for acknowledging processed events to their source,
+// so they are not delivered again after a restart
+type Committer interface {
+	Commit(ctx context.Context) error
+}
+
+// Parser defines an interface for turning a raw update payload, such as a webhook
+// request body, into an Event
+type Parser interface {
+	Parse(data []byte) (Event, error)
+}
+
 type Type int
 
 const (
 	Unknown Type = iota
 	Message
+	Callback
 )
 
-// Event defines the structure of an event, including its type, content text, and
-// optional metadata
+// Event defines the structure of an event, including its type, content text, the key
+// of the conversation it belongs to, and optional metadata. Events with the same key
+// must be processed in order
 type Event struct {
 	Type Type
 	Text string
+	Key  int
 	Meta interface{}
 }
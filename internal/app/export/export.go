@@ -0,0 +1,296 @@
+// Package export writes the saved pages of a user to a file, in one of the formats other
+// bookmark managers and spreadsheets understand
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+)
+
+// Supported formats, named after the extension of their files
+const (
+	JSON     = "json"
+	CSV      = "csv"
+	Markdown = "md"
+	HTML     = "html"
+)
+
+// Formats lists the supported formats, the first one is the default
+var Formats = []string{JSON, CSV, Markdown, HTML}
+
+// aliases are the other names the formats are known by
+var aliases = map[string]string{
+	"markdown":  Markdown,
+	"netscape":  HTML,
+	"bookmarks": HTML,
+	"htm":       HTML,
+}
+
+// fileName is the name of an export file without the extension
+const fileName = "pages"
+
+// Version is the version of the JSON export, increased whenever its layout changes
+const Version = 1
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// File is the layout of the JSON export
+type File struct {
+	Version int     `json:"version"`
+	Pages   []Entry `json:"pages"`
+}
+
+// Entry is a page in the JSON export. Times are unset when they are unknown, or for
+// ReadAt, when the page hasn't been read
+type Entry struct {
+	URL         string     `json:"url"`
+	Title       string     `json:"title,omitempty"`
+	Tags        []string   `json:"tags"`
+	Description string     `json:"description,omitempty"`
+	SavedAt     *time.Time `json:"saved_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+}
+
+// ParseFormat returns the format with the given name or alias, case insensitively. An empty
+// name is the default format
+func ParseFormat(name string) (string, error) {
+	name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "."))
+	if name == "" {
+		return Formats[0], nil
+	}
+
+	if format, ok := aliases[name]; ok {
+		return format, nil
+	}
+
+	for _, format := range Formats {
+		if name == format {
+			return format, nil
+		}
+	}
+
+	return "", e.Wrap(fmt.Sprintf("can't parse format %q", name), ErrUnknownFormat)
+}
+
+// FileName returns the name of the export file in the format
+func FileName(format string) string {
+	return fileName + "." + format
+}
+
+// Write writes the pages to w in the format
+func Write(w io.Writer, format string, pages []*storage.Page) error {
+	var err error
+
+	switch format {
+	case JSON:
+		err = writeJSON(w, pages)
+	case CSV:
+		err = writeCSV(w, pages)
+	case Markdown:
+		err = writeMarkdown(w, pages)
+	case HTML:
+		err = writeHTML(w, pages)
+	default:
+		err = ErrUnknownFormat
+	}
+
+	if err != nil {
+		return e.Wrap(fmt.Sprintf("can't export pages as %s", format), err)
+	}
+
+	return nil
+}
+
+// NewEntry converts a page to its entry in the JSON export
+func NewEntry(p *storage.Page) Entry {
+	entry := Entry{
+		URL:         p.URL,
+		Title:       p.Meta.Title,
+		Tags:        storage.NormalizeTags(p.Tags),
+		Description: p.Description.String,
+	}
+
+	if p.SavedAt.Valid {
+		savedAt := p.SavedAt.Time.UTC()
+		entry.SavedAt = &savedAt
+	}
+
+	if p.ReadAt.Valid {
+		readAt := p.ReadAt.Time.UTC()
+		entry.ReadAt = &readAt
+	}
+
+	return entry
+}
+
+// writeJSON writes the pages as a File
+func writeJSON(w io.Writer, pages []*storage.Page) error {
+	file := File{
+		Version: Version,
+		Pages:   make([]Entry, 0, len(pages)),
+	}
+
+	for _, p := range pages {
+		file.Pages = append(file.Pages, NewEntry(p))
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(file)
+}
+
+// writeCSV writes the pages as a table with a header row. Tags are separated by spaces and
+// times are in RFC 3339, empty when unknown
+func writeCSV(w io.Writer, pages []*storage.Page) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{"url", "title", "tags", "description", "saved_at", "read_at"}); err != nil {
+		return err
+	}
+
+	for _, p := range pages {
+		entry := NewEntry(p)
+
+		record := []string{
+			entry.URL,
+			entry.Title,
+			strings.Join(entry.Tags, " "),
+			entry.Description,
+			formatTime(entry.SavedAt),
+			formatTime(entry.ReadAt),
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// writeMarkdown writes the pages as a task list, read pages are checked
+func writeMarkdown(w io.Writer, pages []*storage.Page) error {
+	var b strings.Builder
+
+	b.WriteString("# Saved pages\n\n")
+
+	for _, p := range pages {
+		entry := NewEntry(p)
+
+		mark := " "
+		if entry.ReadAt != nil {
+			mark = "x"
+		}
+
+		title := entry.Title
+		if title == "" {
+			title = entry.URL
+		}
+
+		fmt.Fprintf(&b, "- [%s] [%s](<%s>)", mark, escapeMarkdown(title), entry.URL)
+
+		for _, tag := range entry.Tags {
+			b.WriteString(" #" + tag)
+		}
+
+		if entry.Description != "" {
+			b.WriteString(" — " + escapeMarkdown(strings.Join(strings.Fields(entry.Description), " ")))
+		}
+
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// writeHTML writes the pages in the Netscape bookmark file format browsers and bookmark
+// managers import. The saved time is the date the bookmark was added, the read time the
+// date it was last visited
+func writeHTML(w io.Writer, pages []*storage.Page) error {
+	var b strings.Builder
+
+	b.WriteString(`<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<!-- This is an automatically generated file.
+     It will be read and overwritten.
+     DO NOT EDIT! -->
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+`)
+
+	for _, p := range pages {
+		entry := NewEntry(p)
+
+		fmt.Fprintf(&b, `    <DT><A HREF="%s"`, html.EscapeString(entry.URL))
+
+		if entry.SavedAt != nil {
+			fmt.Fprintf(&b, ` ADD_DATE="%s"`, strconv.FormatInt(entry.SavedAt.Unix(), 10))
+		}
+
+		if entry.ReadAt != nil {
+			fmt.Fprintf(&b, ` LAST_VISIT="%s"`, strconv.FormatInt(entry.ReadAt.Unix(), 10))
+		}
+
+		if len(entry.Tags) > 0 {
+			fmt.Fprintf(&b, ` TAGS="%s"`, html.EscapeString(strings.Join(entry.Tags, ",")))
+		}
+
+		title := entry.Title
+		if title == "" {
+			title = entry.URL
+		}
+
+		fmt.Fprintf(&b, ">%s</A>\n", html.EscapeString(title))
+
+		if entry.Description != "" {
+			fmt.Fprintf(&b, "    <DD>%s\n", html.EscapeString(entry.Description))
+		}
+	}
+
+	b.WriteString("</DL><p>\n")
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// formatTime formats a time in RFC 3339, or returns an empty string if it is unknown
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// markdownEscaper escapes the characters that would end a link text or start formatting
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"[", `\[`,
+	"]", `\]`,
+	"*", `\*`,
+	"_", `\_`,
+	"`", "\\`",
+)
+
+// escapeMarkdown escapes text to be shown as is in Markdown
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
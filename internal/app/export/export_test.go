@@ -0,0 +1,135 @@
+package export_test
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Braendie/Telegram-bot/internal/app/export"
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+)
+
+var (
+	savedAt = time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	readAt  = time.Date(2024, 3, 2, 12, 30, 0, 0, time.UTC)
+)
+
+func testPages() []*storage.Page {
+	return []*storage.Page{
+		{
+			ID:          1,
+			URL:         "https://example.com/a?x=1&y=2",
+			Tags:        []string{"go", "db"},
+			Description: sql.NullString{String: "About \"databases\"", Valid: true},
+			SavedAt:     sql.NullTime{Time: savedAt, Valid: true},
+			ReadAt:      sql.NullTime{Time: readAt, Valid: true},
+			Meta:        storage.Metadata{Title: "Go [and] databases"},
+		},
+		{
+			ID:  2,
+			URL: "https://example.com/b",
+		},
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: export.JSON,
+			want: `{
+  "version": 1,
+  "pages": [
+    {
+      "url": "https://example.com/a?x=1&y=2",
+      "title": "Go [and] databases",
+      "tags": [
+        "db",
+        "go"
+      ],
+      "description": "About \"databases\"",
+      "saved_at": "2024-03-01T10:00:00Z",
+      "read_at": "2024-03-02T12:30:00Z"
+    },
+    {
+      "url": "https://example.com/b",
+      "tags": []
+    }
+  ]
+}
+`,
+		},
+		{
+			format: export.CSV,
+			want: `url,title,tags,description,saved_at,read_at
+https://example.com/a?x=1&y=2,Go [and] databases,db go,"About ""databases""",2024-03-01T10:00:00Z,2024-03-02T12:30:00Z
+https://example.com/b,,,,,
+`,
+		},
+		{
+			format: export.Markdown,
+			want: `# Saved pages
+
+- [x] [Go \[and\] databases](<https://example.com/a?x=1&y=2>) #db #go — About "databases"
+- [ ] [https://example.com/b](<https://example.com/b>)
+`,
+		},
+		{
+			format: export.HTML,
+			want: `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<!-- This is an automatically generated file.
+     It will be read and overwritten.
+     DO NOT EDIT! -->
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+    <DT><A HREF="https://example.com/a?x=1&amp;y=2" ADD_DATE="1709287200" LAST_VISIT="1709382600" TAGS="db,go">Go [and] databases</A>
+    <DD>About &#34;databases&#34;
+    <DT><A HREF="https://example.com/b">https://example.com/b</A>
+</DL><p>
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := export.Write(&buf, tt.format, testPages()); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"", export.JSON},
+		{"CSV", export.CSV},
+		{"markdown", export.Markdown},
+		{".md", export.Markdown},
+		{"netscape", export.HTML},
+	}
+
+	for _, tt := range tests {
+		got, err := export.ParseFormat(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseFormat(%q): got %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	if _, err := export.ParseFormat("pdf"); !errors.Is(err, export.ErrUnknownFormat) {
+		t.Errorf("ParseFormat(pdf): got error %v, want %v", err, export.ErrUnknownFormat)
+	}
+}
@@ -0,0 +1,169 @@
+package importer
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// defaultFolders are the folders services file bookmarks in when the user didn't pick one,
+// they aren't turned into tags
+var defaultFolders = []string{"unsorted"}
+
+// htmlText is the element whose text is being read
+type htmlText int
+
+const (
+	textNone htmlText = iota
+	textHeading
+	textFolder
+	textLink
+	textDescription
+)
+
+// parseHTML reads a Netscape bookmark file or a Pocket export. Both list links as A elements,
+// bookmark files nest them in DL lists under H3 folders, which become tags, and may follow
+// a link with a DD holding its description. Pocket splits the links under H1 sections, the
+// ones in the archive have been read
+func parseHTML(data []byte, format string) (Result, error) {
+	var (
+		res     = Result{Format: format}
+		folders []string
+		folder  string
+		read    bool
+		mode    htmlText
+		text    strings.Builder
+		pending *Bookmark
+	)
+
+	flush := func() {
+		if pending != nil {
+			res.add(*pending)
+			pending = nil
+		}
+		mode = textNone
+	}
+
+	z := html.NewTokenizer(bytes.NewReader(data))
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		switch tt {
+		case html.TextToken:
+			switch mode {
+			case textHeading, textFolder, textLink:
+				text.Write(z.Text())
+			case textDescription:
+				if pending != nil {
+					pending.Description += string(z.Text())
+				}
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "h1":
+				read = strings.Contains(strings.ToLower(text.String()), "archive")
+				mode = textNone
+			case "h3":
+				if mode == textFolder {
+					folder = text.String()
+				}
+				mode = textNone
+			case "a":
+				if pending != nil && mode == textLink {
+					pending.Title = text.String()
+				}
+				mode = textNone
+			case "dl":
+				flush()
+				if len(folders) > 0 {
+					folders = folders[:len(folders)-1]
+				}
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			attrs := map[string]string{}
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				attrs[string(key)] = string(val)
+			}
+
+			switch string(name) {
+			case "dt", "li":
+				flush()
+			case "h1":
+				flush()
+				text.Reset()
+				mode = textHeading
+			case "h3":
+				flush()
+				text.Reset()
+				folder = ""
+				mode = textFolder
+				if attrs["personal_toolbar_folder"] == "true" || attrs["unfiled_bookmarks_folder"] == "true" {
+					mode = textNone
+				}
+			case "dl":
+				flush()
+				folders = append(folders, folder)
+				folder = ""
+			case "a":
+				flush()
+				text.Reset()
+				mode = textLink
+				pending = &Bookmark{
+					URL:  attrs["href"],
+					Tags: append(folderTags(folders), tagNames(strings.Split(attrs["tags"], ","))...),
+					Read: read,
+				}
+			case "dd":
+				if pending != nil {
+					mode = textDescription
+				}
+			}
+		}
+	}
+
+	flush()
+
+	return res, nil
+}
+
+// tagNames turns names given to tags elsewhere into tags: the leading "#" is dropped and
+// the spaces within are replaced with "_", as a tag ends at a space. Empty names are dropped
+func tagNames(names []string) []string {
+	res := make([]string, 0, len(names))
+	for _, name := range names {
+		tag := strings.Join(strings.Fields(strings.TrimLeft(strings.TrimSpace(name), "#")), "_")
+		if tag != "" {
+			res = append(res, tag)
+		}
+	}
+
+	return res
+}
+
+// folderTags turns the folders a bookmark is filed in into tags, skipping the default ones
+func folderTags(folders []string) []string {
+	names := make([]string, 0, len(folders))
+	for _, folder := range folders {
+		isDefault := false
+		for _, d := range defaultFolders {
+			if strings.EqualFold(strings.TrimSpace(folder), d) {
+				isDefault = true
+			}
+		}
+
+		if !isDefault {
+			names = append(names, folder)
+		}
+	}
+
+	return tagNames(names)
+}
@@ -0,0 +1,209 @@
+// Package importer reads the bookmarks exported by browsers and other read-later services,
+// as well as the files written by the export package, so users can bring their backlog
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/Braendie/Telegram-bot/internal/app/export"
+	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
+)
+
+// Formats Parse recognizes, named the way they are shown to users
+const (
+	Netscape   = "browser bookmarks"
+	PocketHTML = "Pocket"
+	PocketCSV  = "Pocket CSV"
+	Raindrop   = "Raindrop.io CSV"
+	ExportJSON = "JSON export"
+	ExportCSV  = "CSV export"
+)
+
+// detectSize is the size of the beginning of a file looked through to tell its format
+const detectSize = 4096
+
+var ErrUnknownFormat = errors.New("unknown bookmarks format")
+
+// Bookmark is a link read from a file. The folders it was filed in are among its tags
+type Bookmark struct {
+	URL         string
+	Title       string
+	Tags        []string
+	Description string
+	Read        bool
+}
+
+// Result is what was read from a file
+type Result struct {
+	Format    string
+	Bookmarks []Bookmark
+	// Skipped is the number of links that don't lead to web pages, such as bookmarklets
+	Skipped int
+}
+
+// Parse tells the format of the file and reads the bookmarks from it, in the order they
+// appear. It returns ErrUnknownFormat if the format isn't one of the supported ones
+func Parse(data []byte) (Result, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	var (
+		res Result
+		err error
+	)
+
+	head := strings.ToLower(string(data[:min(len(data), detectSize)]))
+	trimmed := strings.TrimSpace(head)
+
+	switch {
+	case strings.HasPrefix(trimmed, "{"):
+		res, err = parseJSON(data)
+	case strings.Contains(head, "<!doctype netscape-bookmark-file"):
+		res, err = parseHTML(data, Netscape)
+	case strings.Contains(head, "<title>pocket export</title>"):
+		res, err = parseHTML(data, PocketHTML)
+	case strings.HasPrefix(trimmed, "<"):
+		err = ErrUnknownFormat
+	default:
+		res, err = parseCSV(data)
+	}
+
+	if err != nil {
+		return Result{}, e.Wrap("can't parse bookmarks", err)
+	}
+
+	return res, nil
+}
+
+// add appends the bookmark to the result, or counts it as skipped if it isn't a web page
+func (res *Result) add(b Bookmark) {
+	b.URL = strings.TrimSpace(b.URL)
+
+	lower := strings.ToLower(b.URL)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		res.Skipped++
+		return
+	}
+
+	b.Title = strings.Join(strings.Fields(b.Title), " ")
+	b.Description = strings.TrimSpace(b.Description)
+
+	res.Bookmarks = append(res.Bookmarks, b)
+}
+
+// parseJSON reads the JSON export of the bot
+func parseJSON(data []byte) (Result, error) {
+	var file export.File
+	if err := json.Unmarshal(data, &file); err != nil || file.Version == 0 {
+		return Result{}, ErrUnknownFormat
+	}
+
+	res := Result{Format: ExportJSON}
+	for _, entry := range file.Pages {
+		res.add(Bookmark{
+			URL:         entry.URL,
+			Title:       entry.Title,
+			Tags:        tagNames(entry.Tags),
+			Description: entry.Description,
+			Read:        entry.ReadAt != nil,
+		})
+	}
+
+	return res, nil
+}
+
+// csvColumns tells the CSV formats apart by the columns of their header
+var csvColumns = []struct {
+	format  string
+	columns []string
+}{
+	{ExportCSV, []string{"url", "title", "tags", "description", "saved_at", "read_at"}},
+	{PocketCSV, []string{"title", "url", "time_added", "tags", "status"}},
+	{Raindrop, []string{"title", "note", "url", "folder", "tags"}},
+}
+
+// parseCSV reads a CSV file, telling its format by the header
+func parseCSV(data []byte) (Result, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	header, err := r.Read()
+	if err != nil {
+		return Result{}, ErrUnknownFormat
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	format := ""
+	for _, f := range csvColumns {
+		if hasColumns(columns, f.columns) {
+			format = f.format
+			break
+		}
+	}
+
+	if format == "" {
+		return Result{}, ErrUnknownFormat
+	}
+
+	res := Result{Format: format}
+
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Result{}, err
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+
+			return ""
+		}
+
+		b := Bookmark{
+			URL:   field("url"),
+			Title: field("title"),
+		}
+
+		switch format {
+		case ExportCSV:
+			b.Tags = tagNames(strings.Fields(field("tags")))
+			b.Description = field("description")
+			b.Read = field("read_at") != ""
+		case PocketCSV:
+			b.Tags = tagNames(strings.Split(field("tags"), "|"))
+			b.Read = field("status") == "archive"
+		case Raindrop:
+			b.Tags = append(folderTags(strings.Split(field("folder"), "/")), tagNames(strings.Split(field("tags"), ","))...)
+			b.Description = field("note")
+		}
+
+		res.add(b)
+	}
+
+	return res, nil
+}
+
+// hasColumns tells whether every one of the names is among the columns
+func hasColumns(columns map[string]int, names []string) bool {
+	for _, name := range names {
+		if _, ok := columns[name]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
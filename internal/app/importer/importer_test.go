@@ -0,0 +1,159 @@
+package importer_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Braendie/Telegram-bot/internal/app/importer"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		wantFormat  string
+		want        []importer.Bookmark
+		wantSkipped int
+	}{
+		{
+			name: "netscape",
+			data: `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+    <DT><H3 ADD_DATE="1700000000" PERSONAL_TOOLBAR_FOLDER="true">Bookmarks bar</H3>
+    <DL><p>
+        <DT><A HREF="https://example.com/bar" ADD_DATE="1700000000">On the bar</A>
+        <DT><H3>Dev Tools</H3>
+        <DL><p>
+            <DT><A HREF="https://example.com/go?a=1&amp;b=2" TAGS="lang,#go">Go &amp; friends</A>
+            <DD>Read it
+              later
+            <DT><A HREF="javascript:alert(1)">Bookmarklet</A>
+        </DL><p>
+    </DL><p>
+    <DT><A HREF="https://example.com/root">Root</A>
+</DL><p>
+`,
+			wantFormat: importer.Netscape,
+			want: []importer.Bookmark{
+				{URL: "https://example.com/bar", Title: "On the bar", Tags: []string{}},
+				{URL: "https://example.com/go?a=1&b=2", Title: "Go & friends", Tags: []string{"Dev_Tools", "lang", "go"}, Description: "Read it\n              later"},
+				{URL: "https://example.com/root", Title: "Root", Tags: []string{}},
+			},
+			wantSkipped: 1,
+		},
+		{
+			name: "pocket",
+			data: `<!DOCTYPE html>
+<html><head><meta charset="UTF-8"><title>Pocket Export</title></head>
+<body>
+<h1>Unread</h1>
+<ul>
+<li><a href="https://example.com/unread" time_added="1700000000" tags="go,db">Unread</a></li>
+</ul>
+<h1>Read Archive</h1>
+<ul>
+<li><a href="https://example.com/read" time_added="1700000000" tags="">Read</a></li>
+</ul>
+</body></html>
+`,
+			wantFormat: importer.PocketHTML,
+			want: []importer.Bookmark{
+				{URL: "https://example.com/unread", Title: "Unread", Tags: []string{"go", "db"}},
+				{URL: "https://example.com/read", Title: "Read", Tags: []string{}, Read: true},
+			},
+		},
+		{
+			name: "pocket csv",
+			data: `title,url,time_added,tags,status
+Unread,https://example.com/unread,1700000000,go|db,unread
+Read,https://example.com/read,1700000000,,archive
+`,
+			wantFormat: importer.PocketCSV,
+			want: []importer.Bookmark{
+				{URL: "https://example.com/unread", Title: "Unread", Tags: []string{"go", "db"}},
+				{URL: "https://example.com/read", Title: "Read", Tags: []string{}, Read: true},
+			},
+		},
+		{
+			name: "raindrop",
+			data: `id,title,note,excerpt,url,folder,tags,created,cover,highlights,favorite
+1,Filed,My note,Excerpt,https://example.com/filed,Work/Side projects,"go, db",2024-03-01T10:00:00.000Z,,,false
+2,Unsorted,,,https://example.com/unsorted,Unsorted,,2024-03-01T10:00:00.000Z,,,false
+`,
+			wantFormat: importer.Raindrop,
+			want: []importer.Bookmark{
+				{URL: "https://example.com/filed", Title: "Filed", Tags: []string{"Work", "Side_projects", "go", "db"}, Description: "My note"},
+				{URL: "https://example.com/unsorted", Title: "Unsorted", Tags: []string{}},
+			},
+		},
+		{
+			name: "csv export",
+			data: `url,title,tags,description,saved_at,read_at
+https://example.com/a,A,db go,About,2024-03-01T10:00:00Z,2024-03-02T12:30:00Z
+https://example.com/b,,,,,
+`,
+			wantFormat: importer.ExportCSV,
+			want: []importer.Bookmark{
+				{URL: "https://example.com/a", Title: "A", Tags: []string{"db", "go"}, Description: "About", Read: true},
+				{URL: "https://example.com/b", Tags: []string{}},
+			},
+		},
+		{
+			name: "json export",
+			data: "\xef\xbb\xbf" + `{
+  "version": 1,
+  "pages": [
+    {"url": "https://example.com/a", "title": "A", "tags": ["db", "go"], "description": "About", "read_at": "2024-03-02T12:30:00Z"},
+    {"url": "https://example.com/b", "tags": []},
+    {"url": "ftp://example.com/c", "tags": []}
+  ]
+}
+`,
+			wantFormat: importer.ExportJSON,
+			want: []importer.Bookmark{
+				{URL: "https://example.com/a", Title: "A", Tags: []string{"db", "go"}, Description: "About", Read: true},
+				{URL: "https://example.com/b", Tags: []string{}},
+			},
+			wantSkipped: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := importer.Parse([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+
+			if res.Format != tt.wantFormat {
+				t.Errorf("format: got %q, want %q", res.Format, tt.wantFormat)
+			}
+
+			if !reflect.DeepEqual(res.Bookmarks, tt.want) {
+				t.Errorf("bookmarks:\ngot  %#v\nwant %#v", res.Bookmarks, tt.want)
+			}
+
+			if res.Skipped != tt.wantSkipped {
+				t.Errorf("skipped: got %d, want %d", res.Skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestParseUnknown(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"just some text",
+		"name,email\nme,me@example.com\n",
+		`{"name": "me"}`,
+		"<html><body><a href=\"https://example.com\">link</a></body></html>",
+	} {
+		if _, err := importer.Parse([]byte(data)); !errors.Is(err, importer.ErrUnknownFormat) {
+			t.Errorf("Parse(%q): got error %v, want %v", data, err, importer.ErrUnknownFormat)
+		}
+	}
+}
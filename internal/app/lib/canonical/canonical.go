@@ -0,0 +1,102 @@
+// Package canonical brings URLs to a single form, so the same page saved through
+// different links is recognized as one
+package canonical
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// defaultScheme is assumed for links sent without one
+const defaultScheme = "https"
+
+// schemePrefix matches a scheme at the start of a link, a "://" further in belongs to the
+// path or query
+var schemePrefix = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+
+// trackingParams are query parameters added by ad networks and mailing tools to follow
+// clicks. They don't change the page and are dropped, along with every utm_* parameter
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"yclid":   true,
+	"igshid":  true,
+}
+
+// defaultPorts are the ports implied by the schemes, they are dropped from hosts
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// URL returns the canonical form of a link: it gets a scheme if it has none, the scheme
+// and host are lowercased and the default port is dropped, tracking parameters and the
+// fragment are removed, and the path loses its trailing slash unless it is the root,
+// which is always "/". A link that can't be parsed is only trimmed
+func URL(raw string) string {
+	raw = strings.TrimSpace(raw)
+
+	s := raw
+	if !schemePrefix.MatchString(s) {
+		s = defaultScheme + "://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if port := u.Port(); port != "" && port == defaultPorts[u.Scheme] {
+		u.Host = strings.TrimSuffix(u.Host, ":"+port)
+	}
+
+	u.Fragment, u.RawFragment = "", ""
+	u.RawQuery = stripTracking(u.RawQuery)
+	u.ForceQuery = false
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path, u.RawPath = "/", ""
+	} else {
+		u.Path = strings.TrimRight(u.Path, "/")
+		u.RawPath = strings.TrimRight(u.RawPath, "/")
+		if u.Path == "" {
+			u.Path, u.RawPath = "/", ""
+		}
+	}
+
+	return u.String()
+}
+
+// stripTracking removes the tracking parameters from a raw query, keeping the rest in
+// their order and encoding
+func stripTracking(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	var kept []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+
+		key, _, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(key); err == nil {
+			key = name
+		}
+
+		key = strings.ToLower(key)
+		if strings.HasPrefix(key, "utm_") || trackingParams[key] {
+			continue
+		}
+
+		kept = append(kept, param)
+	}
+
+	return strings.Join(kept, "&")
+}
@@ -0,0 +1,40 @@
+package canonical
+
+import "testing"
+
+func TestURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"example.com/a", "https://example.com/a"},
+		{"example.com/login?next=https://foo.com&utm_source=x", "https://example.com/login?next=https://foo.com"},
+		{"https://example.com/a/", "https://example.com/a"},
+		{"https://example.com/a?utm_source=x&utm_medium=y", "https://example.com/a"},
+		{"HTTPS://Example.COM/A", "https://example.com/A"},
+		{"http://example.com:80/a", "http://example.com/a"},
+		{"https://example.com:443", "https://example.com/"},
+		{"https://example.com:8443/a", "https://example.com:8443/a"},
+		{"https://example.com", "https://example.com/"},
+		{"https://example.com//", "https://example.com/"},
+		{"https://example.com/a#section", "https://example.com/a"},
+		{"https://example.com/a?", "https://example.com/a"},
+		{"https://example.com/search?q=go&fbclid=1&page=2&gclid=2", "https://example.com/search?q=go&page=2"},
+		{"https://example.com/search?q=a%20b&UTM_Campaign=x", "https://example.com/search?q=a%20b"},
+		{"https://example.com/a%2Fb/", "https://example.com/a%2Fb"},
+		{"  https://example.com/a  ", "https://example.com/a"},
+		{"http://", "http://"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			if got := URL(tt.raw); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+
+			if got := URL(tt.want); got != tt.want {
+				t.Errorf("canonical URL %q changes to %q", tt.want, got)
+			}
+		})
+	}
+}
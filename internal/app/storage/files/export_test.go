@@ -0,0 +1,24 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+)
+
+// SaveLegacy writes the page into a directory named by username, as pages were stored
+// before user IDs were
+func (s Storage) SaveLegacy(userName string, p *storage.Page) error {
+	dir := filepath.Join(s.basePath, userName)
+	if err := os.MkdirAll(dir, defaultPerm); err != nil {
+		return err
+	}
+
+	fName, err := fileName(p)
+	if err != nil {
+		return err
+	}
+
+	return writePage(dir, fName, p)
+}
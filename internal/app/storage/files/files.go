@@ -1,21 +1,35 @@
 package files
 
 import (
+	"bytes"
+	"database/sql"
 	"encoding/gob"
 	"errors"
 	"fmt"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Braendie/Telegram-bot/internal/app/lib/canonical"
 	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
 	"github.com/Braendie/Telegram-bot/internal/app/storage"
 )
 
-const defaultPerm = 0774
+const (
+	defaultPerm = 0774
+	// offsetFile keeps the update offset next to the user directories, which are named by user ID
+	offsetFile = ".offset"
+)
 
-// Storage handles file-based storage for user pages
+// Storage handles file-based storage for user pages. Every user has a directory with
+// one gob encoded file per page and an index file that assigns page IDs and maps tags
+// to pages. Files are replaced atomically and each user directory is guarded by a file
+// lock, so several goroutines or processes can share the same base path
 type Storage struct {
 	basePath string
 }
@@ -27,53 +41,394 @@ func New(basePath string) Storage {
 	}
 }
 
-// Save stores a page for a user by encoding it and saving it to a file
+// Save stores a page for a user by encoding it and saving it to a file, unless the user has
+// already saved it
 func (s Storage) Save(page *storage.Page) error {
-	fPath := filepath.Join(s.basePath, page.UserName)
-
-	if err := os.MkdirAll(fPath, defaultPerm); err != nil {
+	fName, err := fileName(page)
+	if err != nil {
 		return e.Wrap("can't save page", err)
 	}
 
-	fName, err := fileName(page)
+	err = s.withLock(page.UserID, true, func(dir string) error {
+		switch _, err := os.Stat(filepath.Join(dir, fName)); {
+		case err == nil:
+			return storage.ErrPageExists
+		case !errors.Is(err, os.ErrNotExist):
+			return err
+		}
+
+		idx, err := readIndex(dir)
+		if err != nil {
+			return err
+		}
+
+		if err := s.save(dir, idx, page); err != nil {
+			return err
+		}
+
+		return writeIndex(dir, idx)
+	})
 	if err != nil {
 		return e.Wrap("can't save page", err)
 	}
 
-	fPath = filepath.Join(fPath, fName)
-	file, err := os.Create(fPath)
+	return nil
+}
+
+// SaveAll stores the pages that aren't stored yet and returns the number of pages saved.
+// Saved pages get their IDs, the skipped ones keep ID 0. The pages of a user are saved
+// atomically: every page and the index are written to temporary files first, which are only
+// renamed into place once all of them are written. If a rename fails, the pages already
+// renamed are removed again, so the user directory is left as it was
+func (s Storage) SaveAll(pages []*storage.Page) (int, error) {
+	var users []int
+	byUser := make(map[int][]*storage.Page)
+	for _, page := range pages {
+		if _, ok := byUser[page.UserID]; !ok {
+			users = append(users, page.UserID)
+		}
+		byUser[page.UserID] = append(byUser[page.UserID], page)
+	}
+
+	saved := 0
+	for _, userID := range users {
+		var added []*storage.Page
+
+		err := s.withLock(userID, true, func(dir string) error {
+			idx, err := readIndex(dir)
+			if err != nil {
+				return err
+			}
+
+			var files []stagedFile
+			defer func() {
+				for _, f := range files {
+					_ = os.Remove(f.tmp)
+				}
+			}()
+
+			seen := make(map[string]bool)
+			for _, page := range byUser[userID] {
+				fName, err := fileName(page)
+				if err != nil {
+					return err
+				}
+
+				if seen[fName] {
+					continue
+				}
+				seen[fName] = true
+
+				switch _, err := os.Stat(filepath.Join(dir, fName)); {
+				case err == nil:
+					continue
+				case !errors.Is(err, os.ErrNotExist):
+					return err
+				}
+
+				// The page gets its ID even if staging fails, so it is reset with the others
+				added = append(added, page)
+
+				f, err := s.stage(dir, idx, page)
+				if err != nil {
+					return err
+				}
+				files = append(files, f)
+			}
+
+			if len(files) == 0 {
+				return nil
+			}
+
+			data, err := encodeIndex(idx)
+			if err != nil {
+				return err
+			}
+
+			tmp, err := writeTemp(dir, data)
+			if err != nil {
+				return err
+			}
+			files = append(files, stagedFile{tmp: tmp, path: filepath.Join(dir, indexFile)})
+
+			for i, f := range files {
+				if err := os.Rename(f.tmp, f.path); err != nil {
+					// Only page files come before the index, and they are all new
+					for _, done := range files[:i] {
+						_ = os.Remove(done.path)
+					}
+
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			for _, page := range added {
+				page.ID, page.SavedAt = 0, sql.NullTime{}
+			}
+
+			return saved, e.Wrap("can't save pages", err)
+		}
+
+		saved += len(added)
+	}
+
+	return saved, nil
+}
+
+// PickRandom selects a random unread page from the user's stored pages
+func (s Storage) PickRandom(userID int) (*storage.Page, error) {
+	var pages []*storage.Page
+
+	err := s.withLock(userID, false, func(dir string) error {
+		var err error
+		pages, err = dirPages(dir)
+
+		return err
+	})
 	if err != nil {
-		return e.Wrap("can't save page", err)
+		return nil, e.Wrap("can't pick random page", err)
 	}
-	defer func() { _ = file.Close() }()
 
-	if err := gob.NewEncoder(file).Encode(page); err != nil {
-		return e.Wrap("can't save page", err)
+	unread := unreadPages(pages)
+	if len(unread) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	return unread[random().Intn(len(unread))], nil
+}
+
+// PickTag retrieves all unread pages carrying a specific tag for a user, looking them up in the user's index
+func (s Storage) PickTag(userID int, tag string) ([]*storage.Page, error) {
+	var pages []*storage.Page
+
+	err := s.withLock(userID, false, func(dir string) error {
+		idx, err := readIndex(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range idx.Tags[tag] {
+			page, err := decodePage(filepath.Join(dir, name))
+			if err != nil {
+				return err
+			}
+
+			pages = append(pages, page)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, e.Wrap("can't pick tag pages", err)
+	}
+
+	unread := unreadPages(pages)
+	if len(unread) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	sort.Slice(unread, func(i, j int) bool {
+		return unread[i].ID < unread[j].ID
+	})
+
+	return unread, nil
+}
+
+// PickTagRandom retrieves a random unread page carrying a specific tag for a user
+func (s Storage) PickTagRandom(userID int, tag string) (*storage.Page, error) {
+	pages, err := s.PickTag(userID, tag)
+	if err != nil {
+		return nil, e.Wrap("can't pick tag random page", err)
+	}
+
+	return pages[random().Intn(len(pages))], nil
+}
+
+// MarkRead moves a page to the user's history by setting its read timestamp
+func (s Storage) MarkRead(p *storage.Page) error {
+	err := s.update(p, func(page *storage.Page) {
+		page.ReadAt = sql.NullTime{Time: time.Now(), Valid: true}
+	})
+	if err != nil {
+		return e.Wrap("can't mark page as read", err)
 	}
 
 	return nil
 }
 
-// PickRandom selects a random page from the user's stored pages
-func (s Storage) PickRandom(userName string) (*storage.Page, error) {
-	path := filepath.Join(s.basePath, userName)
+// Restore returns a read page from the user's history back to the unread ones
+func (s Storage) Restore(p *storage.Page) error {
+	err := s.update(p, func(page *storage.Page) {
+		page.ReadAt = sql.NullTime{}
+	})
+	if err != nil {
+		return e.Wrap("can't restore page", err)
+	}
+
+	return nil
+}
 
-	files, err := os.ReadDir(path)
+// SetMeta stores the metadata fetched from a saved page
+func (s Storage) SetMeta(p *storage.Page, meta storage.Metadata) error {
+	err := s.update(p, func(page *storage.Page) {
+		page.Meta = meta
+	})
 	if err != nil {
-		return nil, e.Wrap("can't pick random page", err)
+		return e.Wrap("can't set page meta", err)
+	}
+
+	return nil
+}
+
+// History retrieves up to limit read pages for a user, most recently read first. Pages read
+// at the same time come latest saved first, as in the SQL storages
+func (s Storage) History(userID int, limit int) ([]*storage.Page, error) {
+	var pages []*storage.Page
+
+	err := s.withLock(userID, false, func(dir string) error {
+		var err error
+		pages, err = dirPages(dir)
+
+		return err
+	})
+	if err != nil {
+		return nil, e.Wrap("can't get history", err)
+	}
+
+	read := make([]*storage.Page, 0, len(pages))
+	for _, page := range pages {
+		if page.ReadAt.Valid {
+			read = append(read, page)
+		}
 	}
 
-	if len(files) == 0 {
+	if len(read) == 0 {
 		return nil, storage.ErrNoSavedPages
 	}
 
-	src := rand.NewSource(time.Now().UnixNano())
-	r := rand.New(src)
-	n := r.Intn(len(files))
+	sort.Slice(read, func(i, j int) bool {
+		if !read[i].ReadAt.Time.Equal(read[j].ReadAt.Time) {
+			return read[i].ReadAt.Time.After(read[j].ReadAt.Time)
+		}
+
+		return read[i].ID > read[j].ID
+	})
+
+	if len(read) > limit {
+		read = read[:limit]
+	}
+
+	return read, nil
+}
+
+// Tags counts the unread pages of every tag of a user, most used tags first
+func (s Storage) Tags(userID int) ([]storage.TagCount, error) {
+	var pages []*storage.Page
+
+	err := s.withLock(userID, false, func(dir string) error {
+		var err error
+		pages, err = dirPages(dir)
+
+		return err
+	})
+	if err != nil {
+		return nil, e.Wrap("can't get tags", err)
+	}
+
+	counts := make(map[string]int)
+	for _, page := range unreadPages(pages) {
+		for _, tag := range page.Tags {
+			counts[tag]++
+		}
+	}
 
-	file := files[n]
+	if len(counts) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
 
-	return s.decodePage(filepath.Join(path, file.Name()))
+	return storage.SortTagCounts(counts), nil
+}
+
+// Search retrieves up to limit pages of a user matching the query, best match first,
+// skipping the first offset ones
+func (s Storage) Search(userID int, query string, offset, limit int) ([]*storage.Page, error) {
+	var pages []*storage.Page
+
+	err := s.withLock(userID, false, func(dir string) error {
+		var err error
+		pages, err = dirPages(dir)
+
+		return err
+	})
+	if err != nil {
+		return nil, e.Wrap("can't search pages", err)
+	}
+
+	pages = storage.RankPages(pages, storage.SearchTerms(query))
+
+	if offset >= len(pages) {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	return pages[offset:min(offset+limit, len(pages))], nil
+}
+
+// ListPages retrieves up to limit unread pages of a user matching the filter from the cursor on, ordered by ID
+func (s Storage) ListPages(userID int, filter storage.ListFilter, cursor storage.Cursor, limit int) ([]*storage.Page, error) {
+	var pages []*storage.Page
+
+	err := s.withLock(userID, false, func(dir string) error {
+		var err error
+		pages, err = dirPages(dir)
+
+		return err
+	})
+	if err != nil {
+		return nil, e.Wrap("can't list pages", err)
+	}
+
+	pages = slices.DeleteFunc(unreadPages(pages), func(p *storage.Page) bool {
+		return filter.Tag != "" && !slices.Contains(p.Tags, filter.Tag)
+	})
+
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].ID < pages[j].ID
+	})
+
+	pages = cursor.Window(pages, limit)
+	if len(pages) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	return pages, nil
+}
+
+// Pages retrieves every page of a user, read ones included, ordered by ID
+func (s Storage) Pages(userID int) ([]*storage.Page, error) {
+	var pages []*storage.Page
+
+	err := s.withLock(userID, false, func(dir string) error {
+		var err error
+		pages, err = dirPages(dir)
+
+		return err
+	})
+	if err != nil {
+		return nil, e.Wrap("can't get pages", err)
+	}
+
+	if len(pages) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].ID < pages[j].ID
+	})
+
+	return pages, nil
 }
 
 // Remove deletes the specified page file from the storage
@@ -83,10 +438,107 @@ func (s Storage) Remove(p *storage.Page) error {
 		return e.Wrap("can't remove file", err)
 	}
 
-	path := filepath.Join(s.basePath, p.UserName, fileName)
+	err = s.withLock(p.UserID, true, func(dir string) error {
+		idx, err := readIndex(dir)
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, fileName)
+
+		if err := os.Remove(path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return storage.ErrPageNotFound
+			}
+
+			return e.Wrap(fmt.Sprintf("can't remove file %s", path), err)
+		}
+
+		idx.untag(fileName)
+
+		return writeIndex(dir, idx)
+	})
+	if err != nil {
+		return e.Wrap("can't remove file", err)
+	}
+
+	return nil
+}
+
+// RemoveByID deletes the file of the user's page with the given ID from the storage
+func (s Storage) RemoveByID(userID, id int) error {
+	err := s.withLock(userID, true, func(dir string) error {
+		idx, err := readIndex(dir)
+		if err != nil {
+			return err
+		}
+
+		page, err := pageByID(dir, id)
+		if err != nil {
+			return err
+		}
+
+		fName, err := fileName(page)
+		if err != nil {
+			return err
+		}
 
-	if err := os.Remove(path); err != nil {
-		return e.Wrap(fmt.Sprintf("can't remove file %s", path), err)
+		if err := os.Remove(filepath.Join(dir, fName)); err != nil {
+			return e.Wrap(fmt.Sprintf("can't remove file %s", fName), err)
+		}
+
+		idx.untag(fName)
+
+		return writeIndex(dir, idx)
+	})
+	if err != nil {
+		return e.Wrap("can't remove file", err)
+	}
+
+	return nil
+}
+
+// Find decodes the stored page identified by the ID or the URL of p
+func (s Storage) Find(p *storage.Page) (*storage.Page, error) {
+	var page *storage.Page
+
+	err := s.withLock(p.UserID, false, func(dir string) error {
+		var err error
+		page, err = findPage(dir, p)
+
+		return err
+	})
+	if err != nil {
+		return nil, e.Wrap("can't find page", err)
+	}
+
+	return page, nil
+}
+
+// Update replaces the tags and description of the stored page identified by the ID or the URL of p
+func (s Storage) Update(p *storage.Page) error {
+	err := s.withLock(p.UserID, true, func(dir string) error {
+		idx, err := readIndex(dir)
+		if err != nil {
+			return err
+		}
+
+		page, err := findPage(dir, p)
+		if err != nil {
+			return err
+		}
+
+		page.Tags = p.Tags
+		page.Description = p.Description
+
+		if err := s.save(dir, idx, page); err != nil {
+			return err
+		}
+
+		return writeIndex(dir, idx)
+	})
+	if err != nil {
+		return e.Wrap("can't update page", err)
 	}
 
 	return nil
@@ -99,7 +551,7 @@ func (s Storage) IsExists(p *storage.Page) (bool, error) {
 		return false, e.Wrap("can't check if file %s exists", err)
 	}
 
-	path := filepath.Join(s.basePath, p.UserName, fileName)
+	path := filepath.Join(s.userDir(p.UserID), fileName)
 
 	switch _, err = os.Stat(path); {
 	case errors.Is(err, os.ErrNotExist):
@@ -111,21 +563,407 @@ func (s Storage) IsExists(p *storage.Page) (bool, error) {
 	return true, nil
 }
 
+// LinkUser moves the pages saved in a directory named by username, before user IDs were
+// stored, into the user's ID directory. The pages keep their IDs unless the ID is taken
+// there already, and a page the user has already saved again under the ID is merged into
+// the saved one, see storage.MergePages
+func (s Storage) LinkUser(userID int, userName string) error {
+	if userName == "" {
+		return nil
+	}
+
+	oldPath := filepath.Join(s.basePath, userName)
+
+	legacy, err := dirPages(oldPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return e.Wrap("can't link user", err)
+	}
+
+	err = s.withLock(userID, true, func(dir string) error {
+		idx, err := readIndex(dir)
+		if err != nil {
+			return err
+		}
+
+		pages, err := dirPages(dir)
+		if err != nil {
+			return err
+		}
+
+		taken := make(map[int]bool, len(pages))
+		for _, page := range pages {
+			taken[page.ID] = true
+		}
+
+		// Pages are merged in the order they were saved in, so the first one keeps its ID
+		sort.Slice(legacy, func(i, j int) bool {
+			return legacy[i].ID < legacy[j].ID
+		})
+
+		for _, page := range legacy {
+			page.UserID = userID
+
+			saved, err := findPage(dir, &storage.Page{URL: page.URL, UserID: userID})
+			switch {
+			case err == nil:
+				storage.MergePages(saved, page)
+				page = saved
+			case !errors.Is(err, storage.ErrPageNotFound):
+				return err
+			case page.ID == 0 || taken[page.ID]:
+				idx.NextID++
+				page.ID = idx.NextID
+			default:
+				idx.NextID = max(idx.NextID, page.ID)
+			}
+
+			taken[page.ID] = true
+
+			if err := s.save(dir, idx, page); err != nil {
+				return err
+			}
+		}
+
+		return writeIndex(dir, idx)
+	})
+	if err != nil {
+		return e.Wrap("can't link user", err)
+	}
+
+	if err := os.RemoveAll(oldPath); err != nil {
+		return e.Wrap("can't link user", err)
+	}
+
+	return nil
+}
+
+// Offset returns the stored ID of the next update to fetch, or 0 if nothing has been processed yet
+func (s Storage) Offset() (int, error) {
+	data, err := os.ReadFile(filepath.Join(s.basePath, offsetFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, e.Wrap("can't get offset", err)
+	}
+
+	offset, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, e.Wrap("can't get offset", err)
+	}
+
+	return offset, nil
+}
+
+// SetOffset stores the ID of the next update to fetch
+func (s Storage) SetOffset(offset int) error {
+	if err := os.MkdirAll(s.basePath, defaultPerm); err != nil {
+		return e.Wrap("can't set offset", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(s.basePath, offsetFile), []byte(strconv.Itoa(offset))); err != nil {
+		return e.Wrap("can't set offset", err)
+	}
+
+	return nil
+}
+
+// update applies fn to the stored copy of the page and saves the result
+func (s Storage) update(p *storage.Page, fn func(page *storage.Page)) error {
+	fileName, err := fileName(p)
+	if err != nil {
+		return err
+	}
+
+	return s.withLock(p.UserID, true, func(dir string) error {
+		idx, err := readIndex(dir)
+		if err != nil {
+			return err
+		}
+
+		page, err := decodePage(filepath.Join(dir, fileName))
+		if errors.Is(err, os.ErrNotExist) {
+			return storage.ErrPageNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		fn(page)
+
+		if err := s.save(dir, idx, page); err != nil {
+			return err
+		}
+
+		return writeIndex(dir, idx)
+	})
+}
+
+// save writes the page file and records it in the index. It must be called with the
+// user directory locked exclusively
+func (s Storage) save(dir string, idx *index, page *storage.Page) error {
+	f, err := s.stage(dir, idx, page)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.tmp) }()
+
+	return os.Rename(f.tmp, f.path)
+}
+
+// stagedFile is a file written to a temporary path, waiting to be renamed to its path
+type stagedFile struct {
+	tmp  string
+	path string
+}
+
+// stage records the page in the index, assigning an ID to a new one, and writes the page
+// file to a temporary path. It must be called with the user directory locked exclusively
+func (s Storage) stage(dir string, idx *index, page *storage.Page) (stagedFile, error) {
+	fName, err := fileName(page)
+	if err != nil {
+		return stagedFile{}, err
+	}
+
+	if page.ID == 0 {
+		idx.NextID++
+		page.ID = idx.NextID
+		page.SavedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+
+	stored := *page
+	stored.URL = canonical.URL(page.URL)
+	stored.Tags = storage.NormalizeTags(page.Tags)
+
+	data, err := encodePage(&stored)
+	if err != nil {
+		return stagedFile{}, err
+	}
+
+	tmp, err := writeTemp(dir, data)
+	if err != nil {
+		return stagedFile{}, err
+	}
+
+	idx.untag(fName)
+	for _, tag := range stored.Tags {
+		idx.tag(tag, fName)
+	}
+
+	return stagedFile{tmp: tmp, path: filepath.Join(dir, fName)}, nil
+}
+
+// withLock runs fn with the user's directory locked, exclusively for writes or shared for reads
+func (s Storage) withLock(userID int, exclusive bool, fn func(dir string) error) error {
+	dir := s.userDir(userID)
+
+	if err := os.MkdirAll(dir, defaultPerm); err != nil {
+		return err
+	}
+
+	if err := upgradeIndex(dir); err != nil {
+		return e.Wrap("can't upgrade user directory", err)
+	}
+
+	unlock, err := lockDir(dir, exclusive)
+	if err != nil {
+		return e.Wrap("can't lock user directory", err)
+	}
+	defer unlock()
+
+	return fn(dir)
+}
+
+// userDir returns the directory the user's pages are stored in
+func (s Storage) userDir(userID int) string {
+	return filepath.Join(s.basePath, strconv.Itoa(userID))
+}
+
+// findPage decodes the page identified by the ID or the URL of p from the directory
+func findPage(dir string, p *storage.Page) (*storage.Page, error) {
+	if p.ID != 0 {
+		return pageByID(dir, p.ID)
+	}
+
+	fName, err := fileName(p)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := decodePage(filepath.Join(dir, fName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, storage.ErrPageNotFound
+	}
+
+	return page, err
+}
+
+// pageByID decodes the page with the given ID from the directory. Page files are named by
+// URL, so every page is looked through
+func pageByID(dir string, id int) (*storage.Page, error) {
+	pages, err := dirPages(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, page := range pages {
+		if page.ID == id {
+			return page, nil
+		}
+	}
+
+	return nil, storage.ErrPageNotFound
+}
+
+// dirPages decodes every page stored in the directory, skipping the index, lock and temporary files
+func dirPages(path string) ([]*storage.Page, error) {
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make([]*storage.Page, 0, len(files))
+	for _, file := range files {
+		if isServiceFile(file.Name()) {
+			continue
+		}
+
+		page, err := decodePage(filepath.Join(path, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		pages = append(pages, page)
+	}
+
+	return pages, nil
+}
+
+// writePage encodes the page into the file in the directory
+func writePage(dir, fileName string, page *storage.Page) error {
+	data, err := encodePage(page)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(dir, fileName), data)
+}
+
+// encodePage returns the gob encoded form of the page
+func encodePage(page *storage.Page) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(page); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// pageFile is the gob encoded form of a page. Pages are encoded as storage.Page, Tag is
+// only set in files written before a page could carry several tags
+type pageFile struct {
+	ID          int
+	URL         string
+	UserID      int
+	UserName    string
+	Tag         sql.NullString
+	Tags        []string
+	Description sql.NullString
+	ReadAt      sql.NullTime
+	SavedAt     sql.NullTime
+	Meta        storage.Metadata
+}
+
 // decodePage decodes and returns a Page object from the specified file
-func (s Storage) decodePage(filePath string) (*storage.Page, error) {
+func decodePage(filePath string) (*storage.Page, error) {
 	f, err := os.Open(filePath)
 	if err != nil {
 		return nil, e.Wrap("can't decode page", err)
 	}
 
 	defer func() { _ = f.Close() }()
-	var p storage.Page
+	var p pageFile
 
 	if err := gob.NewDecoder(f).Decode(&p); err != nil {
 		return nil, e.Wrap("can't decode page", err)
 	}
 
-	return &p, nil
+	tags := p.Tags
+	if p.Tag.Valid {
+		tags = append(tags, p.Tag.String)
+	}
+
+	return &storage.Page{
+		ID:          p.ID,
+		URL:         p.URL,
+		UserID:      p.UserID,
+		UserName:    p.UserName,
+		Tags:        storage.NormalizeTags(tags),
+		Description: p.Description,
+		ReadAt:      p.ReadAt,
+		SavedAt:     p.SavedAt,
+		Meta:        p.Meta,
+	}, nil
+}
+
+// writeFileAtomic replaces the file with data by writing a temporary file next to it and
+// renaming it over the original, so readers never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := writeTemp(filepath.Dir(path), data)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp) }()
+
+	return os.Rename(tmp, path)
+}
+
+// writeTemp writes data to a new temporary file in the directory, synced to disk, and
+// returns its path
+func writeTemp(dir string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(dir, tmpPattern)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// unreadPages filters out the pages that have been read
+func unreadPages(pages []*storage.Page) []*storage.Page {
+	unread := make([]*storage.Page, 0, len(pages))
+	for _, page := range pages {
+		if !page.ReadAt.Valid {
+			unread = append(unread, page)
+		}
+	}
+
+	return unread
+}
+
+// random returns a freshly seeded random generator
+func random() *rand.Rand {
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
 }
 
 // fileName generates a unique filename for the specified page based on its hash
@@ -0,0 +1,29 @@
+package files_test
+
+import (
+	"testing"
+
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/files"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return files.New(t.TempDir())
+	})
+}
+
+func TestLinkUser(t *testing.T) {
+	storagetest.RunLinkUser(t, func(t *testing.T) (storage.Storage, storagetest.SaveLegacy) {
+		s := files.New(t.TempDir())
+
+		return s, func(t *testing.T, userName string, p *storage.Page) {
+			t.Helper()
+
+			if err := s.SaveLegacy(userName, p); err != nil {
+				t.Fatalf("SaveLegacy %s: %v", p.URL, err)
+			}
+		}
+	})
+}
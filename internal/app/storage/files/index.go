@@ -0,0 +1,312 @@
+package files
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Braendie/Telegram-bot/internal/app/lib/canonical"
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+)
+
+const (
+	// indexFile is the per-user index, lockFile guards the user directory and temporary
+	// files are written next to their target before being renamed over it
+	indexFile  = ".index"
+	lockFile   = ".lock"
+	tmpPattern = ".tmp-*"
+
+	// indexVersion is increased whenever the way pages are indexed changes, older
+	// indexes are rebuilt from the pages by upgradeIndex. Version 2 gives an ID to the
+	// pages saved before pages had one, version 3 moves pages to their canonical URL
+	indexVersion = 3
+)
+
+// index assigns IDs to a user's pages and maps every tag to the files of its pages
+type index struct {
+	Version int
+	NextID  int
+	Tags    map[string][]string
+}
+
+// readIndex loads the index of a user directory. Directories written before the index
+// existed, or indexed by an older version, get one rebuilt from their pages
+func readIndex(dir string) (*index, error) {
+	data, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return rebuildIndex(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &index{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(idx); err != nil {
+		return nil, err
+	}
+
+	if idx.Version < indexVersion {
+		rebuilt, err := rebuildIndex(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		rebuilt.NextID = max(rebuilt.NextID, idx.NextID)
+
+		return rebuilt, nil
+	}
+
+	if idx.Tags == nil {
+		idx.Tags = make(map[string][]string)
+	}
+
+	return idx, nil
+}
+
+// upgradeIndex rebuilds the index of a directory written by an older version. It gives
+// an ID to every page saved without one, in the order the pages were last written, and
+// merges the pages saved under different links to the same canonical URL. The exclusive
+// lock is only taken when the index is outdated
+func upgradeIndex(dir string) error {
+	if current, err := isIndexCurrent(dir); err != nil || current {
+		return err
+	}
+
+	unlock, err := lockDir(dir, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Another process may have upgraded the directory while we were waiting for the lock
+	if current, err := isIndexCurrent(dir); err != nil || current {
+		return err
+	}
+
+	idx, err := rebuildIndex(dir)
+	if err != nil {
+		return err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	type unnumbered struct {
+		name    string
+		page    *storage.Page
+		modTime time.Time
+	}
+
+	var pages []unnumbered
+	for _, file := range files {
+		if isServiceFile(file.Name()) {
+			continue
+		}
+
+		page, err := decodePage(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+
+		if page.ID != 0 {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			return err
+		}
+
+		pages = append(pages, unnumbered{name: file.Name(), page: page, modTime: info.ModTime()})
+	}
+
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].modTime.Before(pages[j].modTime)
+	})
+
+	for _, p := range pages {
+		idx.NextID++
+		p.page.ID = idx.NextID
+
+		if err := writePage(dir, p.name, p.page); err != nil {
+			return err
+		}
+	}
+
+	if err := canonicalizePages(dir); err != nil {
+		return err
+	}
+
+	// Merged pages have left their files, so the tags are indexed again
+	rebuilt, err := rebuildIndex(dir)
+	if err != nil {
+		return err
+	}
+
+	rebuilt.NextID = max(rebuilt.NextID, idx.NextID)
+
+	return writeIndex(dir, rebuilt)
+}
+
+// canonicalizePages moves every page saved under a link that isn't canonical to the file of
+// its canonical URL. A page already stored there is merged with it, keeping the older ID
+func canonicalizePages(dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if isServiceFile(file.Name()) {
+			continue
+		}
+
+		page, err := decodePage(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+
+		url := canonical.URL(page.URL)
+
+		target, err := fileName(page)
+		if err != nil {
+			return err
+		}
+
+		if target == file.Name() && url == page.URL {
+			continue
+		}
+
+		page.URL = url
+
+		if target != file.Name() {
+			stored, err := decodePage(filepath.Join(dir, target))
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+			case err != nil:
+				return err
+			case stored.ID < page.ID:
+				storage.MergePages(stored, page)
+				page = stored
+			default:
+				storage.MergePages(page, stored)
+			}
+		}
+
+		if err := writePage(dir, target, page); err != nil {
+			return err
+		}
+
+		if target != file.Name() {
+			if err := os.Remove(filepath.Join(dir, file.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isIndexCurrent reports whether the directory has an index written by this version
+func isIndexCurrent(dir string) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	idx := &index{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(idx); err != nil {
+		return false, err
+	}
+
+	return idx.Version >= indexVersion, nil
+}
+
+// writeIndex atomically replaces the index of a user directory
+func writeIndex(dir string, idx *index) error {
+	data, err := encodeIndex(idx)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(dir, indexFile), data)
+}
+
+// encodeIndex returns the gob encoded form of the index
+func encodeIndex(idx *index) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(idx); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// rebuildIndex builds an index from the pages of a user directory
+func rebuildIndex(dir string) (*index, error) {
+	idx := &index{
+		Version: indexVersion,
+		Tags:    make(map[string][]string),
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if isServiceFile(file.Name()) {
+			continue
+		}
+
+		page, err := decodePage(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		idx.NextID = max(idx.NextID, page.ID)
+		for _, tag := range page.Tags {
+			idx.tag(tag, file.Name())
+		}
+	}
+
+	return idx, nil
+}
+
+// tag records that the page stored in the file has the tag
+func (idx *index) tag(tag, fileName string) {
+	if !slices.Contains(idx.Tags[tag], fileName) {
+		idx.Tags[tag] = append(idx.Tags[tag], fileName)
+	}
+}
+
+// untag removes the page stored in the file from every tag
+func (idx *index) untag(fileName string) {
+	for tag, files := range idx.Tags {
+		files = slices.DeleteFunc(files, func(name string) bool {
+			return name == fileName
+		})
+
+		if len(files) == 0 {
+			delete(idx.Tags, tag)
+		} else {
+			idx.Tags[tag] = files
+		}
+	}
+}
+
+// isServiceFile reports whether a file in a user directory is not a page
+func isServiceFile(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package files
+
+import "sync"
+
+// locks holds a read-write mutex per user directory
+var locks sync.Map
+
+// lockDir locks a user directory within this process. Platforms without flock
+// don't protect the storage from other processes
+func lockDir(dir string, exclusive bool) (func(), error) {
+	v, _ := locks.LoadOrStore(dir, &sync.RWMutex{})
+	mu := v.(*sync.RWMutex)
+
+	if exclusive {
+		mu.Lock()
+		return mu.Unlock, nil
+	}
+
+	mu.RLock()
+	return mu.RUnlock, nil
+}
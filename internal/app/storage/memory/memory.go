@@ -0,0 +1,402 @@
+package memory
+
+import (
+	"database/sql"
+	"math/rand"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Braendie/Telegram-bot/internal/app/lib/canonical"
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+)
+
+// Storage implements Storage interface in memory. Nothing survives a restart, so it is
+// meant for tests and local experiments
+type Storage struct {
+	mu     sync.RWMutex
+	nextID int
+	pages  map[int][]*storage.Page
+	offset int
+}
+
+// New creates a new, empty instance of Storage
+func New() *Storage {
+	return &Storage{
+		pages: make(map[int][]*storage.Page),
+	}
+}
+
+// Save adds a new page for its user and assigns it an ID, unless the user has already saved it
+func (s *Storage) Save(p *storage.Page) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.find(p) != nil {
+		return storage.ErrPageExists
+	}
+
+	s.nextID++
+	p.ID = s.nextID
+	p.SavedAt = sql.NullTime{Time: time.Now(), Valid: true}
+
+	page := *p
+	page.URL = canonical.URL(p.URL)
+	page.Tags = storage.NormalizeTags(p.Tags)
+	s.pages[p.UserID] = append(s.pages[p.UserID], &page)
+
+	return nil
+}
+
+// SaveAll stores the pages that aren't stored yet, under one lock, and returns the number
+// of pages saved. Saved pages get their IDs, the skipped ones keep ID 0
+func (s *Storage) SaveAll(pages []*storage.Page) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saved := 0
+	for _, p := range pages {
+		if s.find(p) != nil {
+			continue
+		}
+
+		s.nextID++
+		p.ID = s.nextID
+		p.SavedAt = sql.NullTime{Time: time.Now(), Valid: true}
+
+		page := *p
+		page.URL = canonical.URL(p.URL)
+		page.Tags = storage.NormalizeTags(p.Tags)
+		s.pages[p.UserID] = append(s.pages[p.UserID], &page)
+
+		saved++
+	}
+
+	return saved, nil
+}
+
+// PickRandom retrieves a random unread page for a specific user
+func (s *Storage) PickRandom(userID int) (*storage.Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pages := s.filter(userID, func(p *storage.Page) bool {
+		return !p.ReadAt.Valid
+	})
+
+	if len(pages) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	return pages[rand.Intn(len(pages))], nil
+}
+
+// PickTag retrieves all unread pages carrying a specific tag for a user
+func (s *Storage) PickTag(userID int, tag string) ([]*storage.Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pages := s.filter(userID, func(p *storage.Page) bool {
+		return !p.ReadAt.Valid && slices.Contains(p.Tags, tag)
+	})
+
+	if len(pages) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	return pages, nil
+}
+
+// PickTagRandom retrieves a random unread page carrying a specific tag for a user
+func (s *Storage) PickTagRandom(userID int, tag string) (*storage.Page, error) {
+	pages, err := s.PickTag(userID, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return pages[rand.Intn(len(pages))], nil
+}
+
+// Remove deletes a page
+func (s *Storage) Remove(p *storage.Page) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	url := canonical.URL(p.URL)
+
+	pages := s.pages[p.UserID]
+	for i, page := range pages {
+		if page.URL == url {
+			s.pages[p.UserID] = append(pages[:i:i], pages[i+1:]...)
+
+			return nil
+		}
+	}
+
+	return storage.ErrPageNotFound
+}
+
+// RemoveByID deletes the page of a user with the given ID
+func (s *Storage) RemoveByID(userID, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pages := s.pages[userID]
+	for i, page := range pages {
+		if page.ID == id {
+			s.pages[userID] = append(pages[:i:i], pages[i+1:]...)
+
+			return nil
+		}
+	}
+
+	return storage.ErrPageNotFound
+}
+
+// Find returns a copy of the stored page identified by the ID or the URL of p
+func (s *Storage) Find(p *storage.Page) (*storage.Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page := s.lookup(p)
+	if page == nil {
+		return nil, storage.ErrPageNotFound
+	}
+
+	res := *page
+	res.Tags = slices.Clone(page.Tags)
+
+	return &res, nil
+}
+
+// Update replaces the tags and description of the stored page identified by the ID or the URL of p
+func (s *Storage) Update(p *storage.Page) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page := s.lookup(p)
+	if page == nil {
+		return storage.ErrPageNotFound
+	}
+
+	page.Tags = storage.NormalizeTags(p.Tags)
+	page.Description = p.Description
+
+	return nil
+}
+
+// IsExists checks if a page already exists for a specific user
+func (s *Storage) IsExists(p *storage.Page) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.find(p) != nil, nil
+}
+
+// MarkRead moves a page to the user's history by setting its read timestamp
+func (s *Storage) MarkRead(p *storage.Page) error {
+	return s.setReadAt(p, sql.NullTime{Time: time.Now(), Valid: true})
+}
+
+// Restore returns a read page from the user's history back to the unread ones
+func (s *Storage) Restore(p *storage.Page) error {
+	return s.setReadAt(p, sql.NullTime{})
+}
+
+// History retrieves up to limit read pages for a user, most recently read first. Pages read
+// at the same time come latest saved first, as in the SQL storages
+func (s *Storage) History(userID int, limit int) ([]*storage.Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pages := s.filter(userID, func(p *storage.Page) bool {
+		return p.ReadAt.Valid
+	})
+
+	if len(pages) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		if !pages[i].ReadAt.Time.Equal(pages[j].ReadAt.Time) {
+			return pages[i].ReadAt.Time.After(pages[j].ReadAt.Time)
+		}
+
+		return pages[i].ID > pages[j].ID
+	})
+
+	if len(pages) > limit {
+		pages = pages[:limit]
+	}
+
+	return pages, nil
+}
+
+// Tags counts the unread pages of every tag of a user, most used tags first
+func (s *Storage) Tags(userID int) ([]storage.TagCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, page := range s.pages[userID] {
+		if page.ReadAt.Valid {
+			continue
+		}
+
+		for _, tag := range page.Tags {
+			counts[tag]++
+		}
+	}
+
+	if len(counts) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	return storage.SortTagCounts(counts), nil
+}
+
+// Search retrieves up to limit pages of a user matching the query, best match first,
+// skipping the first offset ones
+func (s *Storage) Search(userID int, query string, offset, limit int) ([]*storage.Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pages := storage.RankPages(s.filter(userID, func(p *storage.Page) bool {
+		return true
+	}), storage.SearchTerms(query))
+
+	if offset >= len(pages) {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	return pages[offset:min(offset+limit, len(pages))], nil
+}
+
+// ListPages retrieves up to limit unread pages of a user matching the filter from the cursor on, ordered by ID
+func (s *Storage) ListPages(userID int, filter storage.ListFilter, cursor storage.Cursor, limit int) ([]*storage.Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pages := cursor.Window(s.filter(userID, func(p *storage.Page) bool {
+		return !p.ReadAt.Valid && (filter.Tag == "" || slices.Contains(p.Tags, filter.Tag))
+	}), limit)
+
+	if len(pages) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	return pages, nil
+}
+
+// Pages retrieves every page of a user, read ones included, in the order they were saved
+func (s *Storage) Pages(userID int) ([]*storage.Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pages := s.filter(userID, func(p *storage.Page) bool {
+		return true
+	})
+
+	if len(pages) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	return pages, nil
+}
+
+// LinkUser does nothing, pages kept in memory have always been stored by user ID
+func (s *Storage) LinkUser(userID int, userName string) error {
+	return nil
+}
+
+// Offset returns the stored ID of the next update to fetch
+func (s *Storage) Offset() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.offset, nil
+}
+
+// SetOffset stores the ID of the next update to fetch
+func (s *Storage) SetOffset(offset int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.offset = offset
+
+	return nil
+}
+
+// SetMeta stores the metadata fetched from a saved page
+func (s *Storage) SetMeta(p *storage.Page, meta storage.Metadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page := s.find(p)
+	if page == nil {
+		return storage.ErrPageNotFound
+	}
+
+	page.Meta = meta
+
+	return nil
+}
+
+// setReadAt changes the read timestamp of a stored page
+func (s *Storage) setReadAt(p *storage.Page, readAt sql.NullTime) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page := s.find(p)
+	if page == nil {
+		return storage.ErrPageNotFound
+	}
+
+	page.ReadAt = readAt
+
+	return nil
+}
+
+// find returns the stored page with the same user and canonical URL, or nil
+func (s *Storage) find(p *storage.Page) *storage.Page {
+	url := canonical.URL(p.URL)
+
+	for _, page := range s.pages[p.UserID] {
+		if page.URL == url {
+			return page
+		}
+	}
+
+	return nil
+}
+
+// lookup returns the stored page with the same user and ID, if p has one, or canonical URL
+func (s *Storage) lookup(p *storage.Page) *storage.Page {
+	if p.ID == 0 {
+		return s.find(p)
+	}
+
+	for _, page := range s.pages[p.UserID] {
+		if page.ID == p.ID {
+			return page
+		}
+	}
+
+	return nil
+}
+
+// filter returns copies of the user's pages matching fn, in the order they were saved
+func (s *Storage) filter(userID int, fn func(p *storage.Page) bool) []*storage.Page {
+	var res []*storage.Page
+
+	for _, page := range s.pages[userID] {
+		if fn(page) {
+			p := *page
+			p.Tags = slices.Clone(page.Tags)
+			res = append(res, &p)
+		}
+	}
+
+	return res
+}
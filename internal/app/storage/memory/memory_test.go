@@ -0,0 +1,15 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/memory"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return memory.New()
+	})
+}
@@ -0,0 +1,135 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"github.com/Braendie/Telegram-bot/internal/app/lib/canonical"
+	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+)
+
+// mergeCanonicalURLs brings the URL of every page to its canonical form. Pages of the same
+// owner leading to the same canonical URL are merged into the one saved first, see
+// storage.MergePages. Pages not linked to a user ID yet are grouped by username
+func mergeCanonicalURLs(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, user_id, username, url, description, read_at,
+		title, meta_description, site_name, canonical_url FROM pages ORDER BY id`)
+	if err != nil {
+		return e.Wrap("can't merge canonical urls", err)
+	}
+
+	byID := make(map[int]*storage.Page)
+	groups := make(map[string][]*storage.Page)
+	var keys []string
+
+	for rows.Next() {
+		var (
+			p      storage.Page
+			userID sql.NullInt64
+		)
+
+		err := rows.Scan(&p.ID, &userID, &p.UserName, &p.URL, &p.Description, &p.ReadAt,
+			&p.Meta.Title, &p.Meta.Description, &p.Meta.SiteName, &p.Meta.CanonicalURL)
+		if err != nil {
+			_ = rows.Close()
+			return e.Wrap("can't merge canonical urls", err)
+		}
+
+		owner := "name:" + p.UserName
+		if userID.Valid {
+			owner = "id:" + strconv.FormatInt(userID.Int64, 10)
+		}
+
+		key := owner + " " + canonical.URL(p.URL)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+
+		groups[key] = append(groups[key], &p)
+		byID[p.ID] = &p
+	}
+
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return e.Wrap("can't merge canonical urls", err)
+	}
+	_ = rows.Close()
+
+	if err := loadTags(ctx, tx, byID); err != nil {
+		return e.Wrap("can't merge canonical urls", err)
+	}
+
+	for _, key := range keys {
+		if err := mergePages(ctx, tx, groups[key]); err != nil {
+			return e.Wrap("can't merge canonical urls", err)
+		}
+	}
+
+	return nil
+}
+
+// loadTags fills in the tags of the pages
+func loadTags(ctx context.Context, tx *sql.Tx, byID map[int]*storage.Page) error {
+	rows, err := tx.QueryContext(ctx, `SELECT page_id, tag FROM pages_tags`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id  int
+			tag string
+		)
+		if err := rows.Scan(&id, &tag); err != nil {
+			return err
+		}
+
+		if p, ok := byID[id]; ok {
+			p.Tags = append(p.Tags, tag)
+		}
+	}
+
+	return rows.Err()
+}
+
+// mergePages merges the pages, ordered by ID, into the first one and gives it the canonical URL
+func mergePages(ctx context.Context, tx *sql.Tx, pages []*storage.Page) error {
+	page := pages[0]
+	url := canonical.URL(page.URL)
+
+	if len(pages) == 1 && page.URL == url {
+		return nil
+	}
+
+	for _, dup := range pages[1:] {
+		storage.MergePages(page, dup)
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM pages_tags WHERE page_id = $1`, dup.ID); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM pages WHERE id = $1`, dup.ID); err != nil {
+			return err
+		}
+	}
+
+	query := `UPDATE pages SET url = $1, description = $2, read_at = $3,
+		title = $4, meta_description = $5, site_name = $6, canonical_url = $7 WHERE id = $8`
+	_, err := tx.ExecContext(ctx, query, url, page.Description, page.ReadAt,
+		page.Meta.Title, page.Meta.Description, page.Meta.SiteName, page.Meta.CanonicalURL, page.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range page.Tags {
+		query := `INSERT INTO pages_tags (page_id, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+		if _, err := tx.ExecContext(ctx, query, page.ID, tag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
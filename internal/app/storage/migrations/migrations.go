@@ -0,0 +1,378 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
+)
+
+// Dialects supported by the migrator
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+// Every dialect has its own directory with the same set of versioned migrations, written
+// in the dialect's SQL
+//
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// upSteps are Go functions run after the up script of a migration, in its transaction, for
+// changes SQL can't express the same way in every dialect
+var upSteps = map[int]func(ctx context.Context, tx *sql.Tx) error{
+	8: mergeCanonicalURLs,
+}
+
+var (
+	ErrUnknownDialect = errors.New("unknown dialect")
+	ErrNoMigrations   = errors.New("no migrations to roll back")
+	ErrDiverged       = errors.New("dialects define different migrations")
+)
+
+// Migration is a single versioned schema change with the SQL to apply and to revert it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes a known migration and when it was applied, if it was
+type Status struct {
+	Migration
+	AppliedAt sql.NullTime
+}
+
+// dialect holds the database specific statements the migrator relies on
+type dialect struct {
+	dir                string
+	createVersionTable string
+	insertVersion      string
+	deleteVersion      string
+	lock               string
+	unlock             string
+}
+
+var dialects = map[string]dialect{
+	Postgres: {
+		dir: "postgres",
+		createVersionTable: `CREATE TABLE IF NOT EXISTS schema_version (
+			version    INT PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		insertVersion: `INSERT INTO schema_version (version, name) VALUES ($1, $2)`,
+		deleteVersion: `DELETE FROM schema_version WHERE version = $1`,
+		// Several bot instances starting at once must not migrate concurrently
+		lock:   `SELECT pg_advisory_lock(7294001)`,
+		unlock: `SELECT pg_advisory_unlock(7294001)`,
+	},
+	SQLite: {
+		dir: "sqlite",
+		createVersionTable: `CREATE TABLE IF NOT EXISTS schema_version (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		insertVersion: `INSERT INTO schema_version (version, name) VALUES ($1, $2)`,
+		deleteVersion: `DELETE FROM schema_version WHERE version = $1`,
+	},
+}
+
+// Migrator applies and reverts the embedded migrations, keeping track of the applied
+// versions in the schema_version table
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+}
+
+// New creates a Migrator for the database of the given dialect
+func New(db *sql.DB, dialectName string) (*Migrator, error) {
+	d, ok := dialects[dialectName]
+	if !ok {
+		return nil, e.Wrap(fmt.Sprintf("can't create migrator for %q", dialectName), ErrUnknownDialect)
+	}
+
+	migrations, err := load(d.dir)
+	if err != nil {
+		return nil, e.Wrap("can't create migrator", err)
+	}
+
+	if err := checkShared(migrations); err != nil {
+		return nil, e.Wrap("can't create migrator", err)
+	}
+
+	return &Migrator{
+		db:         db,
+		dialect:    d,
+		migrations: migrations,
+	}, nil
+}
+
+// Up applies every migration that hasn't been applied yet, in version order, and
+// returns the number of applied migrations
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	var count int
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			err := m.run(ctx, conn, migration.Up, upSteps[migration.Version], m.dialect.insertVersion, migration.Version, migration.Name)
+			if err != nil {
+				return e.Wrap(fmt.Sprintf("can't apply migration %04d_%s", migration.Version, migration.Name), err)
+			}
+
+			count++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return count, e.Wrap("can't migrate up", err)
+	}
+
+	return count, nil
+}
+
+// Down reverts the most recently applied migration and returns it
+func (m *Migrator) Down(ctx context.Context) (Migration, error) {
+	var reverted Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+
+			if err := m.run(ctx, conn, migration.Down, nil, m.dialect.deleteVersion, migration.Version); err != nil {
+				return e.Wrap(fmt.Sprintf("can't revert migration %04d_%s", migration.Version, migration.Name), err)
+			}
+
+			reverted = migration
+
+			return nil
+		}
+
+		return ErrNoMigrations
+	})
+	if err != nil {
+		return Migration{}, e.Wrap("can't migrate down", err)
+	}
+
+	return reverted, nil
+}
+
+// Status lists every known migration in version order along with the time it was applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, e.Wrap("can't get migration status", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, e.Wrap("can't get migration status", err)
+	}
+
+	res := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		res = append(res, Status{
+			Migration: migration,
+			AppliedAt: applied[migration.Version],
+		})
+	}
+
+	return res, nil
+}
+
+// locked runs fn on a dedicated connection while holding the migration lock
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	if m.dialect.lock != "" {
+		if _, err := conn.ExecContext(ctx, m.dialect.lock); err != nil {
+			return e.Wrap("can't take migration lock", err)
+		}
+		defer func() { _, _ = conn.ExecContext(context.Background(), m.dialect.unlock) }()
+	}
+
+	return fn(conn)
+}
+
+// applied returns the applied versions with the time they were applied, creating the
+// schema_version table on first use
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]sql.NullTime, error) {
+	if _, err := conn.ExecContext(ctx, m.dialect.createVersionTable); err != nil {
+		return nil, e.Wrap("can't create schema_version table", err)
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, e.Wrap("can't read schema_version table", err)
+	}
+	defer rows.Close()
+
+	res := make(map[int]sql.NullTime)
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, e.Wrap("can't read schema_version table", err)
+		}
+		res[version] = sql.NullTime{Time: appliedAt, Valid: true}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, e.Wrap("can't read schema_version table", err)
+	}
+
+	return res, nil
+}
+
+// run executes a migration script followed by its Go step, if there is one, and records
+// the change of version in one transaction
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, script string, step func(ctx context.Context, tx *sql.Tx) error,
+	versionQuery string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+
+	if step != nil {
+		if err := step(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, versionQuery, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkShared verifies that every dialect defines the same versions under the same names
+// as the given migrations, so the backends can't drift apart
+func checkShared(migrations []Migration) error {
+	for name, d := range dialects {
+		other, err := load(d.dir)
+		if err != nil {
+			return err
+		}
+
+		if len(other) != len(migrations) {
+			return e.Wrap(fmt.Sprintf("%s has %d migrations instead of %d", name, len(other), len(migrations)), ErrDiverged)
+		}
+
+		for i := range other {
+			if other[i].Version != migrations[i].Version || other[i].Name != migrations[i].Name {
+				return e.Wrap(fmt.Sprintf("%s has %04d_%s instead of %04d_%s", name,
+					other[i].Version, other[i].Name, migrations[i].Version, migrations[i].Name), ErrDiverged)
+			}
+		}
+	}
+
+	return nil
+}
+
+// load reads the migrations of a dialect directory. Files are named
+// NNNN_name.up.sql and NNNN_name.down.sql, every version needs both
+func load(dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+
+		base, direction, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), ".")
+		if !ok {
+			return nil, fmt.Errorf("bad migration file name %q", name)
+		}
+
+		versionStr, title, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("bad migration file name %q", name)
+		}
+
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("bad migration version in %q", name)
+		}
+
+		data, err := fs.ReadFile(files, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: title}
+			byVersion[version] = migration
+		}
+
+		switch direction {
+		case "up":
+			migration.Up = string(data)
+		case "down":
+			migration.Down = string(data)
+		default:
+			return nil, fmt.Errorf("bad migration direction in %q", name)
+		}
+	}
+
+	res := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both up and down scripts", migration.Version, migration.Name)
+		}
+
+		res = append(res, *migration)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Version < res[j].Version
+	})
+
+	return res, nil
+}
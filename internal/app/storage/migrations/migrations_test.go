@@ -0,0 +1,120 @@
+package migrations_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/migrations"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/sqlitestorage"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlitestorage.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	m, err := migrations.New(db, migrations.SQLite)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	total := len(status)
+
+	if n, err := m.Up(ctx); err != nil || n != total {
+		t.Fatalf("Up: applied %d of %d migrations, %v", n, total, err)
+	}
+
+	checkApplied(t, m, total)
+
+	if n, err := m.Up(ctx); err != nil || n != 0 {
+		t.Errorf("Up again: applied %d migrations, %v", n, err)
+	}
+
+	// Two users without a username saving the same page can't be told apart by username
+	s := sqlitestorage.New(db)
+	for _, userID := range []int{1, 2} {
+		if err := s.Save(&storage.Page{URL: "https://example.com/a", UserID: userID}); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+
+	for applied := total - 1; applied >= 0; applied-- {
+		reverted, err := m.Down(ctx)
+		if err != nil {
+			t.Fatalf("Down to %d: %v", applied, err)
+		}
+
+		if reverted.Version != status[applied].Version {
+			t.Errorf("Down: reverted %04d, want %04d", reverted.Version, status[applied].Version)
+		}
+
+		checkApplied(t, m, applied)
+
+		if reverted.Version == 4 {
+			checkUserNames(t, db, []string{"id1", "id2"})
+		}
+	}
+
+	if _, err := m.Down(ctx); !errors.Is(err, migrations.ErrNoMigrations) {
+		t.Errorf("Down with nothing applied: got error %v, want %v", err, migrations.ErrNoMigrations)
+	}
+
+	if n, err := m.Up(ctx); err != nil || n != total {
+		t.Fatalf("Up after Down: applied %d of %d migrations, %v", n, total, err)
+	}
+
+	checkApplied(t, m, total)
+}
+
+// checkApplied checks that exactly the first n migrations are applied
+func checkApplied(t *testing.T, m *migrations.Migrator, n int) {
+	t.Helper()
+
+	status, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+
+	for i, st := range status {
+		if st.AppliedAt.Valid != (i < n) {
+			t.Errorf("Status: migration %04d_%s applied = %t with %d applied", st.Version, st.Name, st.AppliedAt.Valid, n)
+		}
+	}
+}
+
+// checkUserNames checks the usernames of the pages, in saving order
+func checkUserNames(t *testing.T, db *sql.DB, want []string) {
+	t.Helper()
+
+	rows, err := db.Query(`SELECT username FROM pages ORDER BY id`)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	defer rows.Close()
+
+	var got []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		got = append(got, name)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got usernames %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS pages (
+    id          SERIAL PRIMARY KEY,
+    username    TEXT NOT NULL,
+    url         TEXT NOT NULL,
+    tag         TEXT,
+    description TEXT
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS pages_username_url_key ON pages (username, url);
+CREATE INDEX IF NOT EXISTS pages_username_tag_idx ON pages (username, tag);
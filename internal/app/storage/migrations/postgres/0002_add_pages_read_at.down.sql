@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS pages_username_read_at_idx;
+
+ALTER TABLE pages DROP COLUMN IF EXISTS read_at;
@@ -0,0 +1,12 @@
+-- Pages are owned by the Telegram user ID from now on. Rows saved before keep a NULL
+-- user_id until the bot sees their owner again and links them by username
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS user_id BIGINT;
+
+DROP INDEX IF EXISTS pages_username_url_key;
+DROP INDEX IF EXISTS pages_username_tag_idx;
+DROP INDEX IF EXISTS pages_username_read_at_idx;
+
+CREATE INDEX IF NOT EXISTS pages_username_idx ON pages (username) WHERE user_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS pages_user_id_url_key ON pages (user_id, url);
+CREATE INDEX IF NOT EXISTS pages_user_id_tag_idx ON pages (user_id, tag);
+CREATE INDEX IF NOT EXISTS pages_user_id_read_at_idx ON pages (user_id, read_at);
@@ -0,0 +1,10 @@
+DROP TRIGGER IF EXISTS pages_tags_search_update ON pages_tags;
+DROP TRIGGER IF EXISTS pages_search_update ON pages;
+
+DROP FUNCTION IF EXISTS pages_tags_search_update();
+DROP FUNCTION IF EXISTS pages_search_update();
+DROP FUNCTION IF EXISTS pages_search_vector(INTEGER, TEXT, TEXT);
+
+DROP INDEX IF EXISTS pages_search_idx;
+
+ALTER TABLE pages DROP COLUMN IF EXISTS search;
@@ -0,0 +1,40 @@
+-- Full-text search over the URL, description and tags of a page. Tags weigh the most and
+-- the URL the least; punctuation in URLs is turned into spaces so their words are found
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS search TSVECTOR;
+
+CREATE OR REPLACE FUNCTION pages_search_vector(INTEGER, TEXT, TEXT) RETURNS TSVECTOR AS $$
+    SELECT setweight(to_tsvector('simple', coalesce(string_agg(tag, ' '), '')), 'A')
+        || setweight(to_tsvector('simple', coalesce($3, '')), 'B')
+        || setweight(to_tsvector('simple', regexp_replace($2, '[^[:alnum:]]+', ' ', 'g')), 'C')
+    FROM pages_tags WHERE page_id = $1
+$$ LANGUAGE SQL STABLE;
+
+CREATE OR REPLACE FUNCTION pages_search_update() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search := pages_search_vector(NEW.id, NEW.url, NEW.description);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION pages_tags_search_update() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        UPDATE pages SET search = pages_search_vector(id, url, description) WHERE id = OLD.page_id;
+    ELSE
+        UPDATE pages SET search = pages_search_vector(id, url, description) WHERE id = NEW.page_id;
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS pages_search_update ON pages;
+CREATE TRIGGER pages_search_update BEFORE INSERT OR UPDATE OF url, description ON pages
+    FOR EACH ROW EXECUTE PROCEDURE pages_search_update();
+
+DROP TRIGGER IF EXISTS pages_tags_search_update ON pages_tags;
+CREATE TRIGGER pages_tags_search_update AFTER INSERT OR DELETE ON pages_tags
+    FOR EACH ROW EXECUTE PROCEDURE pages_tags_search_update();
+
+UPDATE pages SET search = pages_search_vector(id, url, description);
+
+CREATE INDEX IF NOT EXISTS pages_search_idx ON pages USING GIN (search);
@@ -0,0 +1,37 @@
+CREATE OR REPLACE FUNCTION pages_search_vector(INTEGER, TEXT, TEXT) RETURNS TSVECTOR AS $$
+    SELECT setweight(to_tsvector('simple', coalesce(string_agg(tag, ' '), '')), 'A')
+        || setweight(to_tsvector('simple', coalesce($3, '')), 'B')
+        || setweight(to_tsvector('simple', regexp_replace($2, '[^[:alnum:]]+', ' ', 'g')), 'C')
+    FROM pages_tags WHERE page_id = $1
+$$ LANGUAGE SQL STABLE;
+
+CREATE OR REPLACE FUNCTION pages_search_update() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search := pages_search_vector(NEW.id, NEW.url, NEW.description);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION pages_tags_search_update() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        UPDATE pages SET search = pages_search_vector(id, url, description) WHERE id = OLD.page_id;
+    ELSE
+        UPDATE pages SET search = pages_search_vector(id, url, description) WHERE id = NEW.page_id;
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS pages_search_update ON pages;
+CREATE TRIGGER pages_search_update BEFORE INSERT OR UPDATE OF url, description ON pages
+    FOR EACH ROW EXECUTE PROCEDURE pages_search_update();
+
+DROP FUNCTION IF EXISTS pages_search_vector(INTEGER, TEXT, TEXT, TEXT);
+
+ALTER TABLE pages DROP COLUMN IF EXISTS canonical_url;
+ALTER TABLE pages DROP COLUMN IF EXISTS site_name;
+ALTER TABLE pages DROP COLUMN IF EXISTS meta_description;
+ALTER TABLE pages DROP COLUMN IF EXISTS title;
+
+UPDATE pages SET search = pages_search_vector(id, url, description);
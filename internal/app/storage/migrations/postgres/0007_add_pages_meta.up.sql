@@ -0,0 +1,37 @@
+-- Metadata fetched from the page after it is saved, empty until it has been fetched.
+-- The title is searched along with the description
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT '';
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS meta_description TEXT NOT NULL DEFAULT '';
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS site_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS canonical_url TEXT NOT NULL DEFAULT '';
+
+CREATE OR REPLACE FUNCTION pages_search_vector(INTEGER, TEXT, TEXT, TEXT) RETURNS TSVECTOR AS $$
+    SELECT setweight(to_tsvector('simple', coalesce(string_agg(tag, ' '), '')), 'A')
+        || setweight(to_tsvector('simple', coalesce($3, '') || ' ' || coalesce($4, '')), 'B')
+        || setweight(to_tsvector('simple', regexp_replace($2, '[^[:alnum:]]+', ' ', 'g')), 'C')
+    FROM pages_tags WHERE page_id = $1
+$$ LANGUAGE SQL STABLE;
+
+CREATE OR REPLACE FUNCTION pages_search_update() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search := pages_search_vector(NEW.id, NEW.url, NEW.description, NEW.title);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION pages_tags_search_update() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        UPDATE pages SET search = pages_search_vector(id, url, description, title) WHERE id = OLD.page_id;
+    ELSE
+        UPDATE pages SET search = pages_search_vector(id, url, description, title) WHERE id = NEW.page_id;
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS pages_search_update ON pages;
+CREATE TRIGGER pages_search_update BEFORE INSERT OR UPDATE OF url, description, title ON pages
+    FOR EACH ROW EXECUTE PROCEDURE pages_search_update();
+
+DROP FUNCTION IF EXISTS pages_search_vector(INTEGER, TEXT, TEXT);
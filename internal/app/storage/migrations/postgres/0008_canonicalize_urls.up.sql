@@ -0,0 +1,3 @@
+-- Pages saved under different links to the same page are merged and their URLs brought to
+-- the canonical form. That is done in Go by mergeCanonicalURLs, sharing the rules with the bot
+SELECT 1;
@@ -0,0 +1,2 @@
+-- Pages saved before this migration have no saved time
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS saved_at TIMESTAMPTZ;
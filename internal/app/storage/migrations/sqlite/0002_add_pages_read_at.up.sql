@@ -0,0 +1,3 @@
+ALTER TABLE pages ADD COLUMN read_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS pages_username_read_at_idx ON pages (username, read_at);
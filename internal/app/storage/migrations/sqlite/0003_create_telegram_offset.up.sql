@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS telegram_offset (
+    id            INT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    update_offset INT NOT NULL
+);
@@ -0,0 +1,15 @@
+DROP INDEX IF EXISTS pages_user_id_read_at_idx;
+DROP INDEX IF EXISTS pages_user_id_tag_idx;
+DROP INDEX IF EXISTS pages_user_id_url_key;
+DROP INDEX IF EXISTS pages_username_idx;
+
+-- Pages are owned by username again. Users without one keep their pages under a name made
+-- of their ID, and of the pages of one username and URL only the first saved is kept
+UPDATE pages SET username = 'id' || user_id WHERE username = '' AND user_id IS NOT NULL;
+DELETE FROM pages WHERE id NOT IN (SELECT min(id) FROM pages GROUP BY username, url);
+
+ALTER TABLE pages DROP COLUMN user_id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS pages_username_url_key ON pages (username, url);
+CREATE INDEX IF NOT EXISTS pages_username_tag_idx ON pages (username, tag);
+CREATE INDEX IF NOT EXISTS pages_username_read_at_idx ON pages (username, read_at);
@@ -0,0 +1,7 @@
+ALTER TABLE pages ADD COLUMN tag TEXT;
+
+UPDATE pages SET tag = (SELECT min(tag) FROM pages_tags WHERE page_id = pages.id);
+
+CREATE INDEX IF NOT EXISTS pages_user_id_tag_idx ON pages (user_id, tag);
+
+DROP TABLE IF EXISTS pages_tags;
@@ -0,0 +1,17 @@
+-- A page may carry any number of tags. The single tag column is moved into pages_tags,
+-- dropping the "#" some tags were saved with
+CREATE TABLE IF NOT EXISTS pages_tags (
+    page_id INTEGER NOT NULL REFERENCES pages (id) ON DELETE CASCADE,
+    tag     TEXT NOT NULL,
+    PRIMARY KEY (page_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS pages_tags_tag_idx ON pages_tags (tag);
+
+INSERT INTO pages_tags (page_id, tag)
+SELECT id, ltrim(tag, '#') FROM pages WHERE ltrim(tag, '#') <> ''
+ON CONFLICT DO NOTHING;
+
+DROP INDEX IF EXISTS pages_user_id_tag_idx;
+
+ALTER TABLE pages DROP COLUMN tag;
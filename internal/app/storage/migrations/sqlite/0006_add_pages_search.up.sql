@@ -0,0 +1,3 @@
+-- SQLite has no tsvector, sqlitestorage searches pages with LIKE instead, so there is
+-- nothing to add to the schema. The migration keeps the versions shared with PostgreSQL
+SELECT 1;
@@ -0,0 +1,4 @@
+ALTER TABLE pages DROP COLUMN canonical_url;
+ALTER TABLE pages DROP COLUMN site_name;
+ALTER TABLE pages DROP COLUMN meta_description;
+ALTER TABLE pages DROP COLUMN title;
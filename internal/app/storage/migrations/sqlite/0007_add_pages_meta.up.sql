@@ -0,0 +1,5 @@
+-- Metadata fetched from the page after it is saved, empty until it has been fetched
+ALTER TABLE pages ADD COLUMN title TEXT NOT NULL DEFAULT '';
+ALTER TABLE pages ADD COLUMN meta_description TEXT NOT NULL DEFAULT '';
+ALTER TABLE pages ADD COLUMN site_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE pages ADD COLUMN canonical_url TEXT NOT NULL DEFAULT '';
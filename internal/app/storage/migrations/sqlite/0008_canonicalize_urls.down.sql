@@ -0,0 +1,2 @@
+-- Merged pages can't be told apart again, the canonical URLs are kept
+SELECT 1;
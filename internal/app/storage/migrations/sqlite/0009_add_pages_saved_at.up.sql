@@ -0,0 +1,3 @@
+-- Pages saved before this migration have no saved time. SQLite can't add a column defaulting
+-- to CURRENT_TIMESTAMP, new pages are given it on insert
+ALTER TABLE pages ADD COLUMN saved_at TIMESTAMP;
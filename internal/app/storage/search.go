@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Weights of a search term found in the different parts of a page. Backends without
+// full-text search rank pages by the sum of the weights of the terms they contain
+const (
+	TagWeight         = 3
+	DescriptionWeight = 2
+	TitleWeight       = 2
+	URLWeight         = 1
+)
+
+// SearchTerms splits a search query into lowercase words, dropping punctuation
+func SearchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SearchScore ranks a page against the search terms. Every term has to be found in the
+// URL, description, title or tags of the page, otherwise the page doesn't match and 0 is returned
+func SearchScore(p *Page, terms []string) int {
+	url := strings.ToLower(p.URL)
+	description := strings.ToLower(p.Description.String)
+	title := strings.ToLower(p.Meta.Title)
+
+	score := 0
+	for _, term := range terms {
+		termScore := 0
+
+		for _, tag := range p.Tags {
+			if strings.Contains(strings.ToLower(tag), term) {
+				termScore += TagWeight
+				break
+			}
+		}
+
+		if strings.Contains(description, term) {
+			termScore += DescriptionWeight
+		}
+
+		if strings.Contains(title, term) {
+			termScore += TitleWeight
+		}
+
+		if strings.Contains(url, term) {
+			termScore += URLWeight
+		}
+
+		if termScore == 0 {
+			return 0
+		}
+
+		score += termScore
+	}
+
+	return score
+}
+
+// RankPages returns the pages matching the search terms, best match first and the most
+// recently saved first among equally ranked ones
+func RankPages(pages []*Page, terms []string) []*Page {
+	if len(terms) == 0 {
+		return nil
+	}
+
+	scores := make(map[*Page]int, len(pages))
+	res := make([]*Page, 0, len(pages))
+	for _, p := range pages {
+		if score := SearchScore(p, terms); score > 0 {
+			scores[p] = score
+			res = append(res, p)
+		}
+	}
+
+	slices.SortFunc(res, func(a, b *Page) int {
+		if scores[a] != scores[b] {
+			return scores[b] - scores[a]
+		}
+
+		return cmp.Compare(b.ID, a.ID)
+	})
+
+	return res
+}
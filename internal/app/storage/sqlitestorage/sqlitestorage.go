@@ -0,0 +1,59 @@
+package sqlitestorage
+
+import (
+	"database/sql"
+	"net/url"
+
+	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/sqlstorage"
+	_ "modernc.org/sqlite"
+)
+
+// pragmas applied to every connection: WAL lets readers work alongside a writer, the busy
+// timeout makes concurrent writers wait for each other instead of failing
+var pragmas = []string{
+	"busy_timeout(5000)",
+	"journal_mode(WAL)",
+	"foreign_keys(1)",
+}
+
+// Storage implements Storage interface with SQLite, a single local database file.
+// It shares the queries of sqlstorage and the migration set with the PostgreSQL backend,
+// overriding only what relies on PostgreSQL features
+type Storage struct {
+	*sqlstorage.DBStorage
+}
+
+// New creates a new instance of Storage on top of a database opened with Open
+func New(db *sql.DB) *Storage {
+	return &Storage{
+		DBStorage: sqlstorage.New(db),
+	}
+}
+
+// Search retrieves up to limit pages of a user matching the query, best match first,
+// skipping the first offset ones. SQLite has no tsvector, so the terms are matched with LIKE
+func (s *Storage) Search(userID int, query string, offset, limit int) ([]*storage.Page, error) {
+	return s.SearchLike(userID, query, offset, limit)
+}
+
+// Open opens the SQLite database file at path, creating it if needed, and checks its availability
+func Open(path string) (*sql.DB, error) {
+	q := url.Values{}
+	for _, pragma := range pragmas {
+		q.Add("_pragma", pragma)
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+q.Encode())
+	if err != nil {
+		return nil, e.Wrap("can't open sqlite database", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, e.Wrap("can't open sqlite database", err)
+	}
+
+	return db, nil
+}
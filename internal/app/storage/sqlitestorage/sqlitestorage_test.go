@@ -0,0 +1,49 @@
+package sqlitestorage_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/migrations"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/sqlitestorage"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return sqlitestorage.New(openDB(t))
+	})
+}
+
+func TestLinkUser(t *testing.T) {
+	storagetest.RunLinkUser(t, func(t *testing.T) (storage.Storage, storagetest.SaveLegacy) {
+		db := openDB(t)
+
+		return sqlitestorage.New(db), storagetest.SaveLegacyRow(db)
+	})
+}
+
+// openDB opens a new migrated database
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sqlitestorage.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	migrator, err := migrations.New(db, migrations.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
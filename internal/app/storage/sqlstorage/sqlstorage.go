@@ -2,14 +2,21 @@ package sqlstorage
 
 import (
 	"database/sql"
-	"math/rand"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 
+	"github.com/Braendie/Telegram-bot/internal/app/lib/canonical"
 	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
 	"github.com/Braendie/Telegram-bot/internal/app/storage"
 	_ "github.com/lib/pq"
 )
 
-// Storage implements Storage interface with PostgreSQL
+// Storage implements Storage interface with PostgreSQL. The queries stay within SQL that
+// SQLite understands as well, so sqlitestorage builds on it. URLs are stored and looked up
+// in their canonical form
 type DBStorage struct {
 	db *sql.DB
 }
@@ -21,64 +28,184 @@ func New(db *sql.DB) *DBStorage {
 	}
 }
 
-// Save adds a new page to the database
+// pageColumns are the columns of pages scanned by scanPages, in order
+const pageColumns = `pages.id, pages.user_id, pages.username, pages.url, pages.description, pages.read_at,
+	pages.title, pages.meta_description, pages.site_name, pages.canonical_url, pages.saved_at`
+
+// tagsChunk is the number of pages whose tags are loaded by a single query, so the
+// number of parameters stays well below the limits of both databases
+const tagsChunk = 500
+
+// Save adds a new page along with its tags to the database, unless the user has already saved it
 func (s *DBStorage) Save(p *storage.Page) error {
-	return s.db.QueryRow("INSERT INTO pages (username, url, tag, description) VALUES($1, $2, $3, $4) RETURNING id",
-		p.UserName,
-		p.URL,
-		p.Tag,
-		p.Description,
-	).Scan(&p.ID)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return e.Wrap("can't save page", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var id int
+	query := `INSERT INTO pages (user_id, username, url, description, saved_at)
+		VALUES($1, $2, $3, $4, CURRENT_TIMESTAMP) ON CONFLICT (user_id, url) DO NOTHING RETURNING id`
+	err = tx.QueryRow(query, p.UserID, p.UserName, canonical.URL(p.URL), p.Description).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return e.Wrap("can't save page", storage.ErrPageExists)
+	}
+	if err != nil {
+		return e.Wrap("can't save page", err)
+	}
+	p.ID = id
+
+	query = `INSERT INTO pages_tags (page_id, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	for _, tag := range storage.NormalizeTags(p.Tags) {
+		if _, err := tx.Exec(query, p.ID, tag); err != nil {
+			return e.Wrap("can't save page", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return e.Wrap("can't save page", err)
+	}
+	return nil
 }
 
-// PickRandom retrieves a random page for a specific user
-func (s *DBStorage) PickRandom(userName string) (*storage.Page, error) {
-	pages := []*storage.Page{}
-	query := `SELECT username, url, tag, description FROM pages WHERE username = $1`
-	rows, err := s.db.Query(query, userName)
+// SaveAll stores the pages that aren't stored yet in one transaction and returns the number
+// of pages saved. Saved pages get their IDs, the skipped ones keep ID 0
+func (s *DBStorage) SaveAll(pages []*storage.Page) (int, error) {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return nil, e.Wrap("can't pick random page", err)
+		return 0, e.Wrap("can't save pages", err)
 	}
-	defer rows.Close()
+	defer func() { _ = tx.Rollback() }()
 
-	for rows.Next() {
-		p := &storage.Page{}
-		if err := rows.Scan(&p.UserName, &p.URL, &p.Tag, &p.Description); err != nil {
-			return nil, err
+	saved := 0
+	for _, p := range pages {
+		query := `INSERT INTO pages (user_id, username, url, description, saved_at) VALUES($1, $2, $3, $4, CURRENT_TIMESTAMP)
+			ON CONFLICT (user_id, url) DO NOTHING RETURNING id`
+		err := tx.QueryRow(query, p.UserID, p.UserName, canonical.URL(p.URL), p.Description).Scan(&p.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
 		}
-		pages = append(pages, p)
+		if err != nil {
+			return 0, e.Wrap("can't save pages", err)
+		}
+
+		query = `INSERT INTO pages_tags (page_id, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+		for _, tag := range storage.NormalizeTags(p.Tags) {
+			if _, err := tx.Exec(query, p.ID, tag); err != nil {
+				return 0, e.Wrap("can't save pages", err)
+			}
+		}
+
+		saved++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, e.Wrap("can't save pages", err)
+	}
+
+	return saved, nil
+}
+
+// PickRandom retrieves a random unread page for a specific user
+func (s *DBStorage) PickRandom(userID int) (*storage.Page, error) {
+	query := `SELECT ` + pageColumns + ` FROM pages WHERE user_id = $1 AND read_at IS NULL ORDER BY random() LIMIT 1`
+	pages, err := s.pages(query, userID)
+	if err != nil {
+		return nil, e.Wrap("can't pick random page", err)
 	}
 
 	if len(pages) == 0 {
 		return nil, storage.ErrNoSavedPages
 	}
 
-	// Select a random page
-	randompage := pages[rand.Intn(len(pages))]
-	return &storage.Page{
-		UserName:    randompage.UserName,
-		URL:         randompage.URL,
-		Tag:         randompage.Tag,
-		Description: randompage.Description,
-	}, nil
+	return pages[0], nil
 }
 
-// PickTag retrieves all pages with a specific tag for a user
-func (s *DBStorage) PickTag(userName, tag string) ([]*storage.Page, error) {
-	pages := []*storage.Page{}
-	query := `SELECT username, url, tag, description FROM pages WHERE username = $1 AND tag = $2`
-	rows, err := s.db.Query(query, userName, tag)
+// PickTag retrieves all unread pages carrying a specific tag for a user
+func (s *DBStorage) PickTag(userID int, tag string) ([]*storage.Page, error) {
+	query := `SELECT ` + pageColumns + ` FROM pages JOIN pages_tags ON pages_tags.page_id = pages.id
+		WHERE pages.user_id = $1 AND pages_tags.tag = $2 AND pages.read_at IS NULL ORDER BY pages.id`
+	pages, err := s.pages(query, userID, tag)
 	if err != nil {
 		return nil, e.Wrap("can't pick tag pages", err)
 	}
+
+	if len(pages) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	return pages, nil
+}
+
+// PickTagRandom retrieves a random unread page carrying a specific tag for a user
+func (s *DBStorage) PickTagRandom(userID int, tag string) (*storage.Page, error) {
+	query := `SELECT ` + pageColumns + ` FROM pages JOIN pages_tags ON pages_tags.page_id = pages.id
+		WHERE pages.user_id = $1 AND pages_tags.tag = $2 AND pages.read_at IS NULL ORDER BY random() LIMIT 1`
+	pages, err := s.pages(query, userID, tag)
+	if err != nil {
+		return nil, e.Wrap("can't pick tag random page", err)
+	}
+
+	if len(pages) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	return pages[0], nil
+}
+
+// Tags counts the unread pages of every tag of a user, most used tags first
+func (s *DBStorage) Tags(userID int) ([]storage.TagCount, error) {
+	query := `SELECT pages_tags.tag, COUNT(*) FROM pages_tags JOIN pages ON pages.id = pages_tags.page_id
+		WHERE pages.user_id = $1 AND pages.read_at IS NULL
+		GROUP BY pages_tags.tag ORDER BY COUNT(*) DESC, pages_tags.tag`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, e.Wrap("can't get tags", err)
+	}
 	defer rows.Close()
 
+	var tags []storage.TagCount
 	for rows.Next() {
-		p := &storage.Page{}
-		if err := rows.Scan(&p.UserName, &p.URL, &p.Tag, &p.Description); err != nil {
-			return nil, e.Wrap("can't pick tag pages", err)
+		var tag storage.TagCount
+		if err := rows.Scan(&tag.Tag, &tag.Count); err != nil {
+			return nil, e.Wrap("can't get tags", err)
 		}
-		pages = append(pages, p)
+		tags = append(tags, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, e.Wrap("can't get tags", err)
+	}
+
+	if len(tags) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	return tags, nil
+}
+
+// Search retrieves up to limit pages of a user matching the query, best match first,
+// skipping the first offset ones. It relies on the full-text search of PostgreSQL: every
+// term of the query matches the words of the URL, description, title and tags starting
+// with it, and pages are ranked by where the terms are found
+func (s *DBStorage) Search(userID int, query string, offset, limit int) ([]*storage.Page, error) {
+	terms := storage.SearchTerms(query)
+	if len(terms) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	prefixes := make([]string, 0, len(terms))
+	for _, term := range terms {
+		prefixes = append(prefixes, term+":*")
+	}
+
+	q := `SELECT ` + pageColumns + ` FROM pages, to_tsquery('simple', $2) AS tsq
+		WHERE pages.user_id = $1 AND pages.search @@ tsq
+		ORDER BY ts_rank(pages.search, tsq) DESC, pages.id DESC LIMIT $3 OFFSET $4`
+	pages, err := s.pages(q, userID, strings.Join(prefixes, " & "), limit, offset)
+	if err != nil {
+		return nil, e.Wrap("can't search pages", err)
 	}
 
 	if len(pages) == 0 {
@@ -88,39 +215,432 @@ func (s *DBStorage) PickTag(userName, tag string) ([]*storage.Page, error) {
 	return pages, nil
 }
 
-// PickTagRandom retrieves a random page with a specific tag for a user
-func (s *DBStorage) PickTagRandom(userName, tag string) (*storage.Page, error) {
-	pages, err := s.PickTag(userName, tag)
+// SearchLike is Search for databases without full-text search. Every term of the query
+// has to be a part of the URL, description, title or a tag of the page, and pages are
+// ranked with the weights of storage.SearchScore
+func (s *DBStorage) SearchLike(userID int, query string, offset, limit int) ([]*storage.Page, error) {
+	terms := storage.SearchTerms(query)
+	if len(terms) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	args := []interface{}{userID}
+	scores := make([]string, 0, len(terms))
+	matches := make([]string, 0, len(terms))
+	for i, term := range terms {
+		// Terms consist of letters and digits only, so they need no escaping in a pattern
+		args = append(args, "%"+term+"%")
+		param := "$" + strconv.Itoa(len(args))
+
+		scores = append(scores, fmt.Sprintf(`CASE WHEN EXISTS (SELECT 1 FROM pages_tags
+				WHERE pages_tags.page_id = pages.id AND lower(pages_tags.tag) LIKE %[1]s) THEN %[2]d ELSE 0 END
+			+ CASE WHEN lower(pages.description) LIKE %[1]s THEN %[3]d ELSE 0 END
+			+ CASE WHEN lower(pages.title) LIKE %[1]s THEN %[4]d ELSE 0 END
+			+ CASE WHEN lower(pages.url) LIKE %[1]s THEN %[5]d ELSE 0 END AS score%[6]d`,
+			param, storage.TagWeight, storage.DescriptionWeight, storage.TitleWeight, storage.URLWeight, i))
+		matches = append(matches, fmt.Sprintf("score%d", i))
+	}
+
+	args = append(args, limit, offset)
+
+	q := `SELECT id, user_id, username, url, description, read_at,
+			title, meta_description, site_name, canonical_url, saved_at FROM (
+			SELECT ` + pageColumns + `, ` + strings.Join(scores, ", ") + ` FROM pages WHERE pages.user_id = $1
+		) AS matches
+		WHERE ` + strings.Join(matches, " > 0 AND ") + ` > 0
+		ORDER BY ` + strings.Join(matches, " + ") + ` DESC, id DESC
+		LIMIT $` + strconv.Itoa(len(args)-1) + ` OFFSET $` + strconv.Itoa(len(args))
+	pages, err := s.pages(q, args...)
 	if err != nil {
-		return nil, e.Wrap("can't pick tag random page", err)
+		return nil, e.Wrap("can't search pages", err)
+	}
+
+	if len(pages) == 0 {
+		return nil, storage.ErrNoSavedPages
 	}
 
-	// Select a random page
-	randompage := pages[rand.Intn(len(pages))]
-	return &storage.Page{
-		UserName:    randompage.UserName,
-		URL:         randompage.URL,
-		Tag:         randompage.Tag,
-		Description: randompage.Description,
-	}, nil
+	return pages, nil
+}
+
+// ListPages retrieves up to limit unread pages of a user matching the filter from the cursor on, ordered by ID
+func (s *DBStorage) ListPages(userID int, filter storage.ListFilter, cursor storage.Cursor, limit int) ([]*storage.Page, error) {
+	args := []interface{}{userID, cursor.ID, limit}
+	q := `SELECT ` + pageColumns + ` FROM pages WHERE pages.user_id = $1 AND pages.read_at IS NULL`
+
+	if filter.Tag != "" {
+		args = append(args, filter.Tag)
+		q += ` AND EXISTS (SELECT 1 FROM pages_tags WHERE pages_tags.page_id = pages.id AND pages_tags.tag = $4)`
+	}
+
+	// Going backward the pages closest to the cursor are selected first and put back in order below
+	if cursor.Backward {
+		q += ` AND pages.id < $2 ORDER BY pages.id DESC LIMIT $3`
+	} else {
+		q += ` AND pages.id > $2 ORDER BY pages.id LIMIT $3`
+	}
+
+	pages, err := s.pages(q, args...)
+	if err != nil {
+		return nil, e.Wrap("can't list pages", err)
+	}
+
+	if len(pages) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	if cursor.Backward {
+		slices.Reverse(pages)
+	}
+
+	return pages, nil
+}
+
+// Pages retrieves every page of a user, read ones included, ordered by ID
+func (s *DBStorage) Pages(userID int) ([]*storage.Page, error) {
+	pages, err := s.pages(`SELECT `+pageColumns+` FROM pages WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, e.Wrap("can't get pages", err)
+	}
+
+	if len(pages) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	return pages, nil
 }
 
 // Remove a page from the database
 func (s *DBStorage) Remove(p *storage.Page) error {
-	query := `DELETE FROM pages WHERE url = $1 AND username = $2`
-	_, err := s.db.Exec(query, p.URL, p.UserName)
+	query := `DELETE FROM pages WHERE url = $1 AND user_id = $2`
+	res, err := s.db.Exec(query, canonical.URL(p.URL), p.UserID)
 	if err != nil {
 		return e.Wrap("can't remove page", err)
 	}
+	return checkAffected(res, "can't remove page")
+}
+
+// RemoveByID removes the page of a user with the given ID from the database
+func (s *DBStorage) RemoveByID(userID, id int) error {
+	query := `DELETE FROM pages WHERE id = $1 AND user_id = $2`
+	res, err := s.db.Exec(query, id, userID)
+	if err != nil {
+		return e.Wrap("can't remove page", err)
+	}
+	return checkAffected(res, "can't remove page")
+}
+
+// Find retrieves the stored page identified by the ID or the URL of p
+func (s *DBStorage) Find(p *storage.Page) (*storage.Page, error) {
+	where, args := pageKey(p)
+
+	pages, err := s.pages(`SELECT `+pageColumns+` FROM pages WHERE `+where, args...)
+	if err != nil {
+		return nil, e.Wrap("can't find page", err)
+	}
+
+	if len(pages) == 0 {
+		return nil, storage.ErrPageNotFound
+	}
+
+	return pages[0], nil
+}
+
+// Update replaces the tags and description of the stored page identified by the ID or the URL of p
+func (s *DBStorage) Update(p *storage.Page) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return e.Wrap("can't update page", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	where, args := pageKey(p)
+
+	var id int
+	err = tx.QueryRow(`SELECT id FROM pages WHERE `+where, args...).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrPageNotFound
+	}
+	if err != nil {
+		return e.Wrap("can't update page", err)
+	}
+
+	if _, err := tx.Exec(`UPDATE pages SET description = $1 WHERE id = $2`, p.Description, id); err != nil {
+		return e.Wrap("can't update page", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM pages_tags WHERE page_id = $1`, id); err != nil {
+		return e.Wrap("can't update page", err)
+	}
+
+	query := `INSERT INTO pages_tags (page_id, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	for _, tag := range storage.NormalizeTags(p.Tags) {
+		if _, err := tx.Exec(query, id, tag); err != nil {
+			return e.Wrap("can't update page", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return e.Wrap("can't update page", err)
+	}
 	return nil
 }
 
 // IsExists checks if a page already exists for a specific user
 func (s *DBStorage) IsExists(p *storage.Page) (bool, error) {
-	query := `SELECT COUNT(*) FROM pages WHERE url = $1 AND username = $2`
+	query := `SELECT COUNT(*) FROM pages WHERE url = $1 AND user_id = $2`
 	var count int
-	if err := s.db.QueryRow(query, p.URL, p.UserName).Scan(&count); err != nil {
+	if err := s.db.QueryRow(query, canonical.URL(p.URL), p.UserID).Scan(&count); err != nil {
 		return false, e.Wrap("can't check if page exists", err)
 	}
 	return count > 0, nil
 }
+
+// MarkRead moves a page to the user's history by setting its read timestamp
+func (s *DBStorage) MarkRead(p *storage.Page) error {
+	query := `UPDATE pages SET read_at = CURRENT_TIMESTAMP WHERE url = $1 AND user_id = $2`
+	res, err := s.db.Exec(query, canonical.URL(p.URL), p.UserID)
+	if err != nil {
+		return e.Wrap("can't mark page as read", err)
+	}
+	return checkAffected(res, "can't mark page as read")
+}
+
+// Restore returns a read page from the user's history back to the unread ones
+func (s *DBStorage) Restore(p *storage.Page) error {
+	query := `UPDATE pages SET read_at = NULL WHERE url = $1 AND user_id = $2`
+	res, err := s.db.Exec(query, canonical.URL(p.URL), p.UserID)
+	if err != nil {
+		return e.Wrap("can't restore page", err)
+	}
+	return checkAffected(res, "can't restore page")
+}
+
+// SetMeta stores the metadata fetched from a saved page
+func (s *DBStorage) SetMeta(p *storage.Page, meta storage.Metadata) error {
+	query := `UPDATE pages SET title = $1, meta_description = $2, site_name = $3, canonical_url = $4
+		WHERE url = $5 AND user_id = $6`
+	res, err := s.db.Exec(query, meta.Title, meta.Description, meta.SiteName, meta.CanonicalURL, canonical.URL(p.URL), p.UserID)
+	if err != nil {
+		return e.Wrap("can't set page meta", err)
+	}
+	return checkAffected(res, "can't set page meta")
+}
+
+// History retrieves up to limit read pages for a user, most recently read first. Pages read
+// at the same time come latest saved first, so the order doesn't change between calls
+func (s *DBStorage) History(userID int, limit int) ([]*storage.Page, error) {
+	query := `SELECT ` + pageColumns + ` FROM pages
+		WHERE user_id = $1 AND read_at IS NOT NULL ORDER BY read_at DESC, id DESC LIMIT $2`
+	pages, err := s.pages(query, userID, limit)
+	if err != nil {
+		return nil, e.Wrap("can't get history", err)
+	}
+
+	if len(pages) == 0 {
+		return nil, storage.ErrNoSavedPages
+	}
+
+	return pages, nil
+}
+
+// LinkUser attaches the pages saved under a username before user IDs were stored to the
+// user's ID, keeping their IDs. A page the user has already saved again under the ID is
+// merged into the saved one, see storage.MergePages
+func (s *DBStorage) LinkUser(userID int, userName string) error {
+	if userName == "" {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return e.Wrap("can't link user", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	legacy, err := queryPages(tx, `SELECT `+pageColumns+` FROM pages
+		WHERE username = $1 AND user_id IS NULL ORDER BY id`, userName)
+	if err != nil {
+		return e.Wrap("can't link user", err)
+	}
+
+	for _, dup := range legacy {
+		saved, err := queryPages(tx, `SELECT `+pageColumns+` FROM pages WHERE user_id = $1 AND url = $2`, userID, dup.URL)
+		if err != nil {
+			return e.Wrap("can't link user", err)
+		}
+
+		if len(saved) == 0 {
+			if _, err := tx.Exec(`UPDATE pages SET user_id = $1 WHERE id = $2`, userID, dup.ID); err != nil {
+				return e.Wrap("can't link user", err)
+			}
+
+			continue
+		}
+
+		if err := mergeInto(tx, saved[0], dup); err != nil {
+			return e.Wrap("can't link user", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return e.Wrap("can't link user", err)
+	}
+	return nil
+}
+
+// mergeInto folds the duplicate into the stored page and deletes it
+func mergeInto(tx *sql.Tx, page, dup *storage.Page) error {
+	storage.MergePages(page, dup)
+
+	query := `UPDATE pages SET description = $1, read_at = $2, title = $3, meta_description = $4,
+		site_name = $5, canonical_url = $6, saved_at = $7 WHERE id = $8`
+	_, err := tx.Exec(query, page.Description, page.ReadAt, page.Meta.Title, page.Meta.Description,
+		page.Meta.SiteName, page.Meta.CanonicalURL, page.SavedAt, page.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `INSERT INTO pages_tags (page_id, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	for _, tag := range page.Tags {
+		if _, err := tx.Exec(query, page.ID, tag); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM pages_tags WHERE page_id = $1`, dup.ID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM pages WHERE id = $1`, dup.ID)
+
+	return err
+}
+
+// queryer runs queries on the database or within a transaction
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// pages runs a query selecting pageColumns and returns the pages along with their tags
+func (s *DBStorage) pages(query string, args ...interface{}) ([]*storage.Page, error) {
+	return queryPages(s.db, query, args...)
+}
+
+// queryPages runs a query selecting pageColumns with q and returns the pages along with
+// their tags. Pages not linked to a user ID yet get user ID 0
+func queryPages(q queryer, query string, args ...interface{}) ([]*storage.Page, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pages := []*storage.Page{}
+	for rows.Next() {
+		var (
+			p      = &storage.Page{}
+			userID sql.NullInt64
+		)
+		err := rows.Scan(&p.ID, &userID, &p.UserName, &p.URL, &p.Description, &p.ReadAt,
+			&p.Meta.Title, &p.Meta.Description, &p.Meta.SiteName, &p.Meta.CanonicalURL, &p.SavedAt)
+		if err != nil {
+			return nil, err
+		}
+		p.UserID = int(userID.Int64)
+		pages = append(pages, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(pages); i += tagsChunk {
+		if err := loadTags(q, pages[i:min(i+tagsChunk, len(pages))]); err != nil {
+			return nil, err
+		}
+	}
+
+	return pages, nil
+}
+
+// loadTags fills in the tags of the pages with a single query run with q
+func loadTags(q queryer, pages []*storage.Page) error {
+	if len(pages) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*storage.Page, len(pages))
+	params := make([]string, 0, len(pages))
+	args := make([]interface{}, 0, len(pages))
+	for _, p := range pages {
+		byID[p.ID] = p
+		args = append(args, p.ID)
+		params = append(params, "$"+strconv.Itoa(len(args)))
+	}
+
+	query := `SELECT page_id, tag FROM pages_tags WHERE page_id IN (` + strings.Join(params, ", ") + `) ORDER BY tag`
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id  int
+			tag string
+		)
+		if err := rows.Scan(&id, &tag); err != nil {
+			return err
+		}
+		byID[id].Tags = append(byID[id].Tags, tag)
+	}
+
+	return rows.Err()
+}
+
+// pageKey returns the condition matching the page of its user by ID, if it has one, or by
+// canonical URL, along with its arguments
+func pageKey(p *storage.Page) (string, []interface{}) {
+	if p.ID != 0 {
+		return `id = $1 AND user_id = $2`, []interface{}{p.ID, p.UserID}
+	}
+
+	return `url = $1 AND user_id = $2`, []interface{}{canonical.URL(p.URL), p.UserID}
+}
+
+// checkAffected returns storage.ErrPageNotFound if the statement didn't touch any page
+func checkAffected(res sql.Result, msg string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return e.Wrap(msg, err)
+	}
+	if n == 0 {
+		return e.Wrap(msg, storage.ErrPageNotFound)
+	}
+	return nil
+}
+
+// Offset returns the stored ID of the next update to fetch, or 0 if nothing has been processed yet
+func (s *DBStorage) Offset() (int, error) {
+	query := `SELECT update_offset FROM telegram_offset WHERE id = 1`
+	var offset int
+	err := s.db.QueryRow(query).Scan(&offset)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, e.Wrap("can't get offset", err)
+	}
+	return offset, nil
+}
+
+// SetOffset stores the ID of the next update to fetch
+func (s *DBStorage) SetOffset(offset int) error {
+	query := `INSERT INTO telegram_offset (id, update_offset) VALUES (1, $1)
+		ON CONFLICT (id) DO UPDATE SET update_offset = EXCLUDED.update_offset`
+	if _, err := s.db.Exec(query, offset); err != nil {
+		return e.Wrap("can't set offset", err)
+	}
+	return nil
+}
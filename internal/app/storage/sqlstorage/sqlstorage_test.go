@@ -0,0 +1,61 @@
+package sqlstorage_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/migrations"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/sqlstorage"
+	"github.com/Braendie/Telegram-bot/internal/app/storage/storagetest"
+)
+
+// databaseURLEnv names the variable with the DSN of a PostgreSQL database the tests may wipe
+const databaseURLEnv = "TEST_DATABASE_URL"
+
+func TestStorage(t *testing.T) {
+	databaseURL := os.Getenv(databaseURLEnv)
+	if databaseURL == "" {
+		t.Skipf("%s is not set", databaseURLEnv)
+	}
+
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	migrator, err := migrations.New(db, migrations.Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		truncate(t, db)
+
+		return sqlstorage.New(db)
+	})
+
+	t.Run("LinkUser", func(t *testing.T) {
+		storagetest.RunLinkUser(t, func(t *testing.T) (storage.Storage, storagetest.SaveLegacy) {
+			truncate(t, db)
+
+			return sqlstorage.New(db), storagetest.SaveLegacyRow(db)
+		})
+	})
+}
+
+// truncate empties the tables of the database
+func truncate(t *testing.T, db *sql.DB) {
+	t.Helper()
+
+	if _, err := db.Exec("TRUNCATE pages, pages_tags, telegram_offset RESTART IDENTITY"); err != nil {
+		t.Fatal(err)
+	}
+}
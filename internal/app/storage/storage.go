@@ -6,43 +6,217 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"slices"
+	"strconv"
+	"strings"
 
+	"github.com/Braendie/Telegram-bot/internal/app/lib/canonical"
 	"github.com/Braendie/Telegram-bot/internal/app/lib/e"
 )
 
-var ErrNoSavedPages = errors.New("no saved page")
+var (
+	ErrNoSavedPages = errors.New("no saved page")
+	ErrPageNotFound = errors.New("page not found")
+	ErrPageExists   = errors.New("page already exists")
+)
 
-// Storage defines the interface for managing pages, including saving, retrieving,
-// deleting, and checking if pages exist
+// Storage defines the interface for managing the pages saved by Telegram users. Every
+// backend behaves the same way, storagetest checks a backend against these rules
 type Storage interface {
+	// Save stores a new page and assigns it an ID, or returns ErrPageExists and leaves the
+	// stored page as it is if the user has already saved it
 	Save(p *Page) error
-	PickRandom(userName string) (*Page, error)
+	// SaveAll stores the pages not stored yet in one go, all of them or none, and returns the
+	// number saved. Saved pages get their IDs, the skipped ones keep ID 0
+	SaveAll(pages []*Page) (int, error)
+	// PickRandom returns a random unread page of the user, or ErrNoSavedPages
+	PickRandom(userID int) (*Page, error)
+	// Remove deletes the page with the URL of p, or returns ErrPageNotFound
 	Remove(p *Page) error
+	// RemoveByID deletes the page of the user with the ID, or returns ErrPageNotFound
+	RemoveByID(userID, id int) error
+	// Find returns the stored page identified by the ID of p if it is set and by its URL
+	// otherwise, or ErrPageNotFound
+	Find(p *Page) (*Page, error)
+	// Update replaces the tags and description of the page identified like in Find, or
+	// returns ErrPageNotFound
+	Update(p *Page) error
+	// IsExists reports whether the user has saved a page with the URL of p
 	IsExists(p *Page) (bool, error)
-	PickTag(userName, tag string) ([]*Page, error)
-	PickTagRandom(userName, tag string) (*Page, error)
+	// PickTag returns the unread pages of the user carrying the tag, or ErrNoSavedPages
+	PickTag(userID int, tag string) ([]*Page, error)
+	// PickTagRandom returns a random unread page of the user carrying the tag, or ErrNoSavedPages
+	PickTagRandom(userID int, tag string) (*Page, error)
+	// MarkRead moves the page to the history, or returns ErrPageNotFound
+	MarkRead(p *Page) error
+	// Restore moves a read page back to the unread ones, or returns ErrPageNotFound
+	Restore(p *Page) error
+	// History returns up to limit read pages of the user, most recently read first, or ErrNoSavedPages
+	History(userID int, limit int) ([]*Page, error)
+	// Tags counts the unread pages of every tag of the user, or returns ErrNoSavedPages
+	Tags(userID int) ([]TagCount, error)
+	// Search returns up to limit pages of the user matching the query, read ones included,
+	// best match first and skipping the first offset ones, or ErrNoSavedPages
+	Search(userID int, query string, offset, limit int) ([]*Page, error)
+	// ListPages returns up to limit unread pages of the user matching the filter from the
+	// cursor on, in the order they were saved, or ErrNoSavedPages
+	ListPages(userID int, filter ListFilter, cursor Cursor, limit int) ([]*Page, error)
+	// Pages returns every page of the user, read ones included, in the order they were
+	// saved, or ErrNoSavedPages
+	Pages(userID int) ([]*Page, error)
+	// SetMeta stores the metadata fetched from the page, or returns ErrPageNotFound
+	SetMeta(p *Page, meta Metadata) error
+	// LinkUser attaches the pages saved by username before user IDs were stored to the ID
+	LinkUser(userID int, userName string) error
+}
+
+// OffsetStorage defines the interface for persisting the ID of the next update to fetch,
+// so the bot resumes where it stopped after a restart
+type OffsetStorage interface {
+	Offset() (int, error)
+	SetOffset(offset int) error
 }
 
-// Page represents the structure of a saved page
+// Page represents the structure of a saved page. Backends store the URL in its canonical
+// form and look pages up by it, see canonical.URL. UserID identifies the owner, UserName
+// is only kept as the owner's username at the time the page was saved. Tags are kept
+// without the leading "#", sorted and without duplicates, see NormalizeTags. Description is
+// the one given by the user, Meta is what the page tells about itself. SavedAt is unknown
+// for pages saved before the time was stored
 type Page struct {
 	ID          int
 	URL         string
+	UserID      int
 	UserName    string
-	Tag         sql.NullString
+	Tags        []string
 	Description sql.NullString
+	ReadAt      sql.NullTime
+	SavedAt     sql.NullTime
+	Meta        Metadata
+}
+
+// Metadata is what a page tells about itself, fetched after it is saved. Every field is
+// empty until the page has been fetched, or if the page doesn't tell
+type Metadata struct {
+	Title        string
+	Description  string
+	SiteName     string
+	CanonicalURL string
+}
+
+// TagCount is a tag along with the number of unread pages carrying it
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// SortTagCounts turns the number of pages of every tag into TagCounts, most used tags
+// first and alphabetically among equally used ones
+func SortTagCounts(counts map[string]int) []TagCount {
+	res := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		res = append(res, TagCount{Tag: tag, Count: count})
+	}
+
+	slices.SortFunc(res, func(a, b TagCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+
+		return strings.Compare(a.Tag, b.Tag)
+	})
+
+	return res
+}
+
+// NormalizeTags strips the leading "#" from every tag, drops empty ones and returns
+// the rest sorted and without duplicates. Backends store tags in this form
+func NormalizeTags(tags []string) []string {
+	res := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimLeft(strings.TrimSpace(tag), "#")
+		if tag != "" {
+			res = append(res, tag)
+		}
+	}
+
+	slices.Sort(res)
+
+	return slices.Compact(res)
 }
 
-// Hash calculates a unique SHA-1 hash for the page based on its URL and username
+// Hash calculates a unique SHA-1 hash for the page based on its canonical URL and user ID
 func (p Page) Hash() (string, error) {
 	h := sha1.New()
 
-	if _, err := io.WriteString(h, p.URL); err != nil {
+	if _, err := io.WriteString(h, canonical.URL(p.URL)); err != nil {
 		return "", e.Wrap("can't calculate hash", err)
 	}
 
-	if _, err := io.WriteString(h, p.UserName); err != nil {
+	if _, err := io.WriteString(h, strconv.Itoa(p.UserID)); err != nil {
 		return "", e.Wrap("can't calculate hash", err)
 	}
 
 	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
+
+// MergePages folds a duplicate of a page into it, once their URLs turned out to be the same
+// page: the tags are joined, what the page lacks is taken from the duplicate and the page
+// stays unread if either of them is. The page counts as saved when the first of them was
+func MergePages(page, dup *Page) {
+	page.Tags = NormalizeTags(append(slices.Clone(page.Tags), dup.Tags...))
+
+	if !page.Description.Valid {
+		page.Description = dup.Description
+	}
+
+	switch {
+	case !page.ReadAt.Valid:
+	case !dup.ReadAt.Valid:
+		page.ReadAt = dup.ReadAt
+	case dup.ReadAt.Time.After(page.ReadAt.Time):
+		page.ReadAt = dup.ReadAt
+	}
+
+	if dup.SavedAt.Valid && (!page.SavedAt.Valid || dup.SavedAt.Time.Before(page.SavedAt.Time)) {
+		page.SavedAt = dup.SavedAt
+	}
+
+	if page.Meta == (Metadata{}) {
+		page.Meta = dup.Meta
+	}
+}
+
+// ListFilter narrows down the pages returned by ListPages. The zero value lists every unread page
+type ListFilter struct {
+	// Tag keeps only the pages carrying it, if set
+	Tag string
+}
+
+// Cursor is a position in a list of pages ordered by ID. ListPages returns the pages after
+// the one with ID, or the ones before it if Backward is set. The zero value starts the list
+type Cursor struct {
+	ID       int
+	Backward bool
+}
+
+// Window returns up to limit pages from the cursor on. The pages have to be sorted by ID
+// and are returned in the same order, whichever direction the cursor points to
+func (c Cursor) Window(pages []*Page, limit int) []*Page {
+	if c.Backward {
+		end, _ := slices.BinarySearchFunc(pages, c.ID, func(p *Page, id int) int {
+			return p.ID - id
+		})
+
+		return pages[max(end-limit, 0):end]
+	}
+
+	start, found := slices.BinarySearchFunc(pages, c.ID, func(p *Page, id int) int {
+		return p.ID - id
+	})
+	if found {
+		start++
+	}
+
+	return pages[start:min(start+limit, len(pages))]
+}
@@ -0,0 +1,915 @@
+// Package storagetest is a conformance suite every storage.Storage backend has to pass
+package storagetest
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Braendie/Telegram-bot/internal/app/storage"
+)
+
+// Factory returns a new, empty storage for a single test. Cleanup should be registered on t
+type Factory func(t *testing.T) storage.Storage
+
+const (
+	userID      = 1
+	otherUserID = 2
+)
+
+// Run checks the backend created by factory against the behaviour described by storage.Storage
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.Storage)
+	}{
+		{"Empty", testEmpty},
+		{"SaveAndIsExists", testSaveAndIsExists},
+		{"SaveAll", testSaveAll},
+		{"PickRandom", testPickRandom},
+		{"PickTag", testPickTag},
+		{"PickTagRandom", testPickTagRandom},
+		{"MultipleTags", testMultipleTags},
+		{"Tags", testTags},
+		{"Search", testSearch},
+		{"ListPages", testListPages},
+		{"Pages", testPages},
+		{"Remove", testRemove},
+		{"RemoveByID", testRemoveByID},
+		{"FindAndUpdate", testFindAndUpdate},
+		{"MarkReadAndRestore", testMarkReadAndRestore},
+		{"History", testHistory},
+		{"SetMeta", testSetMeta},
+		{"CanonicalURL", testCanonicalURL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, factory(t))
+		})
+	}
+}
+
+// SaveLegacy stores a page the way it was stored before user IDs were, owned by the username
+type SaveLegacy func(t *testing.T, userName string, p *storage.Page)
+
+// LegacyFactory returns a new, empty storage for a single test along with the function
+// storing pages in it the way they were stored before user IDs were
+type LegacyFactory func(t *testing.T) (storage.Storage, SaveLegacy)
+
+// RunLinkUser checks that LinkUser attaches the pages saved by username to the user ID, for
+// the backends that stored pages by username before
+func RunLinkUser(t *testing.T, factory LegacyFactory) {
+	s, saveLegacy := factory(t)
+
+	savedAt := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	readAt := time.Date(2024, 3, 2, 12, 30, 0, 0, time.UTC)
+
+	page := newPage(userID, "https://example.com/a", "go")
+	save(t, s, page)
+
+	saveLegacy(t, "user", &storage.Page{
+		ID:          1,
+		URL:         "https://example.com/a",
+		UserName:    "user",
+		Tags:        []string{"db"},
+		Description: sql.NullString{String: "About a", Valid: true},
+		SavedAt:     sql.NullTime{Time: savedAt, Valid: true},
+	})
+	saveLegacy(t, "user", &storage.Page{
+		ID:       2,
+		URL:      "https://example.com/b",
+		UserName: "user",
+		ReadAt:   sql.NullTime{Time: readAt, Valid: true},
+		SavedAt:  sql.NullTime{Time: savedAt, Valid: true},
+	})
+
+	for range 2 {
+		if err := s.LinkUser(userID, "user"); err != nil {
+			t.Fatalf("LinkUser: %v", err)
+		}
+	}
+
+	got, err := s.Pages(userID)
+	if err != nil {
+		t.Fatalf("Pages: %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("Pages: got %d pages, want 2", len(got))
+	}
+
+	// The legacy copy of a page saved again is merged into it, the page keeps its ID
+	a := got[0]
+	comparePage(t, a, &storage.Page{
+		ID:          page.ID,
+		URL:         "https://example.com/a",
+		UserID:      userID,
+		UserName:    "user",
+		Tags:        []string{"db", "go"},
+		Description: sql.NullString{String: "About a", Valid: true},
+	})
+
+	if !a.SavedAt.Valid || !a.SavedAt.Time.Equal(savedAt) {
+		t.Errorf("merged page: got saved at %v, want %v", a.SavedAt, savedAt)
+	}
+
+	b := got[1]
+	if b.URL != "https://example.com/b" || b.UserID != userID || b.ID == a.ID {
+		t.Errorf("linked page: got %+v", b)
+	}
+
+	if !b.SavedAt.Valid || !b.SavedAt.Time.Equal(savedAt) {
+		t.Errorf("linked page: got saved at %v, want %v", b.SavedAt, savedAt)
+	}
+
+	if !b.ReadAt.Valid || !b.ReadAt.Time.Equal(readAt) {
+		t.Errorf("linked page: got read at %v, want %v", b.ReadAt, readAt)
+	}
+
+	if _, err := s.Pages(otherUserID); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("Pages of another user: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+}
+
+// SaveLegacyRow returns the SaveLegacy of the SQL backends, which stored pages in rows without a user ID
+func SaveLegacyRow(db *sql.DB) SaveLegacy {
+	return func(t *testing.T, userName string, p *storage.Page) {
+		t.Helper()
+
+		var id int
+		query := `INSERT INTO pages (username, url, description, read_at, saved_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+		if err := db.QueryRow(query, userName, p.URL, p.Description, p.ReadAt, p.SavedAt).Scan(&id); err != nil {
+			t.Fatalf("insert legacy page %s: %v", p.URL, err)
+		}
+
+		for _, tag := range p.Tags {
+			if _, err := db.Exec(`INSERT INTO pages_tags (page_id, tag) VALUES ($1, $2)`, id, tag); err != nil {
+				t.Fatalf("insert legacy tag %s: %v", tag, err)
+			}
+		}
+	}
+}
+
+// testEmpty checks that every read reports ErrNoSavedPages and every change ErrPageNotFound on an empty storage
+func testEmpty(t *testing.T, s storage.Storage) {
+	if _, err := s.PickRandom(userID); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("PickRandom: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+
+	if _, err := s.PickTag(userID, "go"); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("PickTag: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+
+	if _, err := s.PickTagRandom(userID, "go"); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("PickTagRandom: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+
+	if _, err := s.History(userID, 10); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("History: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+
+	if _, err := s.Tags(userID); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("Tags: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+
+	if _, err := s.Search(userID, "go", 0, 10); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("Search: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+
+	if _, err := s.ListPages(userID, storage.ListFilter{}, storage.Cursor{}, 10); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("ListPages: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+
+	if _, err := s.Pages(userID); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("Pages: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+
+	missing := newPage(userID, "https://example.com/missing")
+
+	if err := s.Remove(missing); !errors.Is(err, storage.ErrPageNotFound) {
+		t.Errorf("Remove: got error %v, want %v", err, storage.ErrPageNotFound)
+	}
+
+	if err := s.RemoveByID(userID, 1); !errors.Is(err, storage.ErrPageNotFound) {
+		t.Errorf("RemoveByID: got error %v, want %v", err, storage.ErrPageNotFound)
+	}
+
+	if _, err := s.Find(missing); !errors.Is(err, storage.ErrPageNotFound) {
+		t.Errorf("Find: got error %v, want %v", err, storage.ErrPageNotFound)
+	}
+
+	if err := s.Update(missing); !errors.Is(err, storage.ErrPageNotFound) {
+		t.Errorf("Update: got error %v, want %v", err, storage.ErrPageNotFound)
+	}
+
+	if err := s.MarkRead(missing); !errors.Is(err, storage.ErrPageNotFound) {
+		t.Errorf("MarkRead: got error %v, want %v", err, storage.ErrPageNotFound)
+	}
+
+	if err := s.Restore(missing); !errors.Is(err, storage.ErrPageNotFound) {
+		t.Errorf("Restore: got error %v, want %v", err, storage.ErrPageNotFound)
+	}
+
+	if err := s.SetMeta(missing, storage.Metadata{Title: "Missing"}); !errors.Is(err, storage.ErrPageNotFound) {
+		t.Errorf("SetMeta: got error %v, want %v", err, storage.ErrPageNotFound)
+	}
+}
+
+func testSaveAndIsExists(t *testing.T, s storage.Storage) {
+	page := newPage(userID, "https://example.com/a", "go")
+
+	if isExists(t, s, page) {
+		t.Fatal("IsExists: page exists before it was saved")
+	}
+
+	save(t, s, page)
+
+	if page.ID == 0 {
+		t.Error("Save: page ID is not assigned")
+	}
+
+	if !isExists(t, s, page) {
+		t.Error("IsExists: saved page doesn't exist")
+	}
+
+	if isExists(t, s, newPage(otherUserID, page.URL)) {
+		t.Error("IsExists: page of another user exists")
+	}
+
+	other := newPage(userID, "https://example.com/b")
+	save(t, s, other)
+
+	if other.ID == page.ID {
+		t.Errorf("Save: pages got the same ID %d", page.ID)
+	}
+
+	// Saving the same page twice leaves the stored one as it is
+	before, err := s.Find(page)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+
+	again := newPage(userID, "example.com/a/", "db")
+	again.Description = sql.NullString{String: "About a", Valid: true}
+	if err := s.Save(again); !errors.Is(err, storage.ErrPageExists) {
+		t.Errorf("Save twice: got error %v, want %v", err, storage.ErrPageExists)
+	}
+
+	if again.ID != 0 {
+		t.Errorf("Save twice: page got ID %d", again.ID)
+	}
+
+	stored, err := s.Find(page)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	comparePage(t, stored, page)
+
+	if stored.SavedAt.Valid != before.SavedAt.Valid || !stored.SavedAt.Time.Equal(before.SavedAt.Time) {
+		t.Errorf("Save twice: got saved at %v, want %v", stored.SavedAt, before.SavedAt)
+	}
+
+	if pages, err := s.Pages(userID); err != nil || len(pages) != 2 {
+		t.Errorf("Pages: got %d pages, %v, want 2", len(pages), err)
+	}
+}
+
+// testSaveAll checks that pages already stored, or listed twice, are skipped and the rest saved
+func testSaveAll(t *testing.T, s storage.Storage) {
+	stored := newPage(userID, "https://example.com/a")
+	save(t, s, stored)
+
+	pages := []*storage.Page{
+		newPage(userID, "https://example.com/b", "go"),
+		newPage(userID, "example.com/a/"),
+		newPage(userID, "https://example.com/c"),
+		newPage(userID, "https://example.com/b?utm_source=x", "db"),
+	}
+
+	saved, err := s.SaveAll(pages)
+	if err != nil {
+		t.Fatalf("SaveAll: %v", err)
+	}
+
+	if saved != 2 {
+		t.Errorf("SaveAll: got %d pages saved, want 2", saved)
+	}
+
+	if pages[0].ID == 0 || pages[2].ID == 0 || pages[0].ID == pages[2].ID || pages[0].ID == stored.ID {
+		t.Errorf("SaveAll: saved pages got IDs %d and %d", pages[0].ID, pages[2].ID)
+	}
+
+	if pages[1].ID != 0 || pages[3].ID != 0 {
+		t.Errorf("SaveAll: skipped pages got IDs %d and %d", pages[1].ID, pages[3].ID)
+	}
+
+	got, err := s.PickTag(userID, "go")
+	if err != nil {
+		t.Fatalf("PickTag: %v", err)
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("PickTag: got %d pages, want 1", len(got))
+	}
+	comparePage(t, got[0], pages[0])
+
+	if _, err := s.PickTag(userID, "db"); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("PickTag: got error %v for the tag of a skipped page, want %v", err, storage.ErrNoSavedPages)
+	}
+
+	if !isExists(t, s, pages[2]) {
+		t.Error("IsExists: page saved with SaveAll doesn't exist")
+	}
+
+	if saved, err := s.SaveAll(nil); err != nil || saved != 0 {
+		t.Errorf("SaveAll of no pages: got %d, %v", saved, err)
+	}
+}
+
+func testPickRandom(t *testing.T, s storage.Storage) {
+	page := newPage(userID, "https://example.com/a", "go")
+	page.Description = sql.NullString{String: "a page about go", Valid: true}
+	save(t, s, page)
+	save(t, s, newPage(otherUserID, "https://example.com/other"))
+
+	got, err := s.PickRandom(userID)
+	if err != nil {
+		t.Fatalf("PickRandom: %v", err)
+	}
+
+	comparePage(t, got, page)
+
+	if _, err := s.PickRandom(3); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("PickRandom of a user without pages: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+}
+
+func testPickTag(t *testing.T, s storage.Storage) {
+	first := newPage(userID, "https://example.com/a", "go")
+	second := newPage(userID, "https://example.com/b", "go")
+	save(t, s, first)
+	save(t, s, newPage(userID, "https://example.com/c", "rust"))
+	save(t, s, newPage(userID, "https://example.com/d"))
+	save(t, s, second)
+	save(t, s, newPage(otherUserID, "https://example.com/e", "go"))
+
+	got, err := s.PickTag(userID, "go")
+	if err != nil {
+		t.Fatalf("PickTag: %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("PickTag: got %d pages, want 2", len(got))
+	}
+
+	comparePage(t, got[0], first)
+	comparePage(t, got[1], second)
+
+	if _, err := s.PickTag(userID, "python"); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("PickTag of an unknown tag: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+}
+
+func testPickTagRandom(t *testing.T, s storage.Storage) {
+	page := newPage(userID, "https://example.com/a", "go")
+	save(t, s, page)
+	save(t, s, newPage(userID, "https://example.com/b", "rust"))
+
+	for i := 0; i < 5; i++ {
+		got, err := s.PickTagRandom(userID, "go")
+		if err != nil {
+			t.Fatalf("PickTagRandom: %v", err)
+		}
+
+		comparePage(t, got, page)
+	}
+
+	if _, err := s.PickTagRandom(otherUserID, "go"); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("PickTagRandom of another user: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+}
+
+func testMultipleTags(t *testing.T, s storage.Storage) {
+	page := newPage(userID, "https://example.com/a", "#go", "db", "go")
+	save(t, s, page)
+	save(t, s, newPage(userID, "https://example.com/b"))
+
+	for _, tag := range []string{"go", "db"} {
+		got, err := s.PickTag(userID, tag)
+		if err != nil {
+			t.Fatalf("PickTag %s: %v", tag, err)
+		}
+
+		if len(got) != 1 {
+			t.Fatalf("PickTag %s: got %d pages, want 1", tag, len(got))
+		}
+
+		comparePage(t, got[0], page)
+
+		if want := []string{"db", "go"}; !slices.Equal(got[0].Tags, want) {
+			t.Errorf("PickTag %s: got tags %q, want %q", tag, got[0].Tags, want)
+		}
+	}
+
+	got, err := s.PickTagRandom(userID, "db")
+	if err != nil {
+		t.Fatalf("PickTagRandom: %v", err)
+	}
+
+	comparePage(t, got, page)
+}
+
+func testTags(t *testing.T, s storage.Storage) {
+	save(t, s, newPage(userID, "https://example.com/a", "go", "db"))
+	save(t, s, newPage(userID, "https://example.com/b", "go"))
+	save(t, s, newPage(userID, "https://example.com/c", "rust"))
+	save(t, s, newPage(userID, "https://example.com/d"))
+	save(t, s, newPage(otherUserID, "https://example.com/e", "python"))
+
+	read := newPage(userID, "https://example.com/f", "go", "zig")
+	save(t, s, read)
+	if err := s.MarkRead(read); err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+
+	got, err := s.Tags(userID)
+	if err != nil {
+		t.Fatalf("Tags: %v", err)
+	}
+
+	want := []storage.TagCount{{Tag: "go", Count: 2}, {Tag: "db", Count: 1}, {Tag: "rust", Count: 1}}
+	if !slices.Equal(got, want) {
+		t.Errorf("Tags: got %v, want %v", got, want)
+	}
+}
+
+func testSearch(t *testing.T, s storage.Storage) {
+	byURL := newPage(userID, "https://golang.org/doc")
+	byTag := newPage(userID, "https://example.com/a", "golang")
+	byDescription := newPage(userID, "https://example.com/b")
+	byDescription.Description = sql.NullString{String: "Notes on Golang generics", Valid: true}
+	read := newPage(userID, "https://example.com/golang-read")
+
+	for _, page := range []*storage.Page{byURL, byTag, byDescription, read} {
+		save(t, s, page)
+	}
+	save(t, s, newPage(userID, "https://example.com/rust", "rust"))
+	save(t, s, newPage(otherUserID, "https://golang.org/other", "golang"))
+
+	if err := s.MarkRead(read); err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+
+	got, err := s.Search(userID, "golang", 0, 10)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+
+	if len(got) != 4 {
+		t.Fatalf("Search: got %d pages, want 4", len(got))
+	}
+
+	// A match in a tag outranks one in the description, which outranks one in the URL
+	comparePage(t, got[0], byTag)
+	comparePage(t, got[1], byDescription)
+
+	page, err := s.Search(userID, "golang", 1, 1)
+	if err != nil {
+		t.Fatalf("Search with offset: %v", err)
+	}
+
+	if len(page) != 1 {
+		t.Fatalf("Search with offset: got %d pages, want 1", len(page))
+	}
+
+	comparePage(t, page[0], got[1])
+
+	if _, err := s.Search(userID, "golang", 4, 10); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("Search past the results: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+
+	got, err = s.Search(userID, "GOLANG, generics!", 0, 10)
+	if err != nil {
+		t.Fatalf("Search of several terms: %v", err)
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("Search of several terms: got %d pages, want 1", len(got))
+	}
+
+	comparePage(t, got[0], byDescription)
+
+	if _, err := s.Search(userID, "python", 0, 10); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("Search without matches: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+}
+
+func testListPages(t *testing.T, s storage.Storage) {
+	pages := []*storage.Page{
+		newPage(userID, "https://example.com/1", "go"),
+		newPage(userID, "https://example.com/2"),
+		newPage(userID, "https://example.com/3", "go"),
+		newPage(userID, "https://example.com/4"),
+		newPage(userID, "https://example.com/5", "go"),
+	}
+
+	read := newPage(userID, "https://example.com/read", "go")
+
+	for i, page := range pages {
+		save(t, s, page)
+		if i == 2 {
+			save(t, s, read)
+			save(t, s, newPage(otherUserID, "https://example.com/other", "go"))
+		}
+	}
+
+	if err := s.MarkRead(read); err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+
+	list := func(filter storage.ListFilter, cursor storage.Cursor, limit int, want ...*storage.Page) {
+		t.Helper()
+
+		got, err := s.ListPages(userID, filter, cursor, limit)
+		if err != nil {
+			t.Fatalf("ListPages %+v from %+v: %v", filter, cursor, err)
+		}
+
+		if len(got) != len(want) {
+			t.Fatalf("ListPages %+v from %+v: got %d pages, want %d", filter, cursor, len(got), len(want))
+		}
+
+		for i := range want {
+			comparePage(t, got[i], want[i])
+		}
+	}
+
+	all := storage.ListFilter{}
+	list(all, storage.Cursor{}, 2, pages[0], pages[1])
+	list(all, storage.Cursor{ID: pages[1].ID}, 2, pages[2], pages[3])
+	list(all, storage.Cursor{ID: pages[3].ID}, 2, pages[4])
+	list(all, storage.Cursor{ID: pages[4].ID, Backward: true}, 2, pages[2], pages[3])
+	list(all, storage.Cursor{ID: pages[1].ID, Backward: true}, 2, pages[0])
+
+	tagged := storage.ListFilter{Tag: "go"}
+	list(tagged, storage.Cursor{}, 2, pages[0], pages[2])
+	list(tagged, storage.Cursor{ID: pages[2].ID}, 2, pages[4])
+	list(tagged, storage.Cursor{ID: pages[4].ID, Backward: true}, 5, pages[0], pages[2])
+
+	if _, err := s.ListPages(userID, all, storage.Cursor{ID: pages[4].ID}, 2); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("ListPages past the end: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+
+	if _, err := s.ListPages(userID, all, storage.Cursor{ID: pages[0].ID, Backward: true}, 2); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("ListPages before the start: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+}
+
+// testPages checks that every page of the user is returned in the order it was saved, with the time it was saved
+func testPages(t *testing.T, s storage.Storage) {
+	var want []*storage.Page
+	for _, url := range []string{"https://example.com/a", "https://example.com/b", "https://example.com/c"} {
+		page := newPage(userID, url, "go")
+		save(t, s, page)
+		want = append(want, page)
+	}
+	save(t, s, newPage(otherUserID, "https://example.com/other"))
+
+	if err := s.MarkRead(want[1]); err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+
+	got, err := s.Pages(userID)
+	if err != nil {
+		t.Fatalf("Pages: %v", err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("Pages: got %d pages, want %d", len(got), len(want))
+	}
+
+	for i := range got {
+		comparePage(t, got[i], want[i])
+
+		if !got[i].SavedAt.Valid {
+			t.Errorf("Pages: page %s has no saved time", got[i].URL)
+		}
+	}
+
+	if !got[1].ReadAt.Valid {
+		t.Error("Pages: read page is not marked read")
+	}
+}
+
+func testRemove(t *testing.T, s storage.Storage) {
+	page := newPage(userID, "https://example.com/a", "go")
+	save(t, s, page)
+	save(t, s, newPage(otherUserID, page.URL, "go"))
+
+	if err := s.Remove(newPage(userID, page.URL)); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	if isExists(t, s, page) {
+		t.Error("IsExists: removed page exists")
+	}
+
+	if _, err := s.PickRandom(userID); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("PickRandom after Remove: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+
+	if _, err := s.PickTag(userID, "go"); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("PickTag after Remove: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+
+	if err := s.Remove(page); !errors.Is(err, storage.ErrPageNotFound) {
+		t.Errorf("second Remove: got error %v, want %v", err, storage.ErrPageNotFound)
+	}
+
+	if !isExists(t, s, newPage(otherUserID, page.URL)) {
+		t.Error("IsExists: page of another user was removed")
+	}
+}
+
+func testRemoveByID(t *testing.T, s storage.Storage) {
+	page := newPage(userID, "https://example.com/a", "go")
+	save(t, s, page)
+	other := newPage(userID, "https://example.com/b", "go")
+	save(t, s, other)
+
+	if err := s.RemoveByID(otherUserID, page.ID); !errors.Is(err, storage.ErrPageNotFound) {
+		t.Errorf("RemoveByID of another user: got error %v, want %v", err, storage.ErrPageNotFound)
+	}
+
+	if err := s.RemoveByID(userID, page.ID); err != nil {
+		t.Fatalf("RemoveByID: %v", err)
+	}
+
+	if isExists(t, s, page) {
+		t.Error("IsExists: removed page exists")
+	}
+
+	got, err := s.PickTag(userID, "go")
+	if err != nil {
+		t.Fatalf("PickTag: %v", err)
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("PickTag after RemoveByID: got %d pages, want 1", len(got))
+	}
+	comparePage(t, got[0], other)
+
+	if err := s.RemoveByID(userID, page.ID); !errors.Is(err, storage.ErrPageNotFound) {
+		t.Errorf("second RemoveByID: got error %v, want %v", err, storage.ErrPageNotFound)
+	}
+}
+
+// testFindAndUpdate checks that pages are found and updated by ID or by URL
+func testFindAndUpdate(t *testing.T, s storage.Storage) {
+	page := newPage(userID, "https://example.com/a", "go", "db")
+	page.Description = sql.NullString{String: "a page about go", Valid: true}
+	save(t, s, page)
+	save(t, s, newPage(userID, "https://example.com/b"))
+
+	got, err := s.Find(&storage.Page{ID: page.ID, UserID: userID})
+	if err != nil {
+		t.Fatalf("Find by ID: %v", err)
+	}
+	comparePage(t, got, page)
+
+	got, err = s.Find(newPage(userID, "example.com/a/"))
+	if err != nil {
+		t.Fatalf("Find by URL: %v", err)
+	}
+	comparePage(t, got, page)
+
+	if _, err := s.Find(&storage.Page{ID: page.ID, UserID: otherUserID}); !errors.Is(err, storage.ErrPageNotFound) {
+		t.Errorf("Find of another user: got error %v, want %v", err, storage.ErrPageNotFound)
+	}
+
+	if err := s.Update(&storage.Page{ID: page.ID, UserID: userID, Tags: []string{"rust"}}); err != nil {
+		t.Fatalf("Update by ID: %v", err)
+	}
+
+	want := *page
+	want.Tags, want.Description = []string{"rust"}, sql.NullString{}
+
+	got, err = s.Find(&storage.Page{ID: page.ID, UserID: userID})
+	if err != nil {
+		t.Fatalf("Find after Update: %v", err)
+	}
+	comparePage(t, got, &want)
+
+	if _, err := s.PickTag(userID, "go"); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("PickTag of a removed tag: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+
+	want.Description = sql.NullString{String: "updated", Valid: true}
+	update := newPage(userID, "https://example.com/a?utm_source=x", "rust")
+	update.Description = want.Description
+	if err := s.Update(update); err != nil {
+		t.Fatalf("Update by URL: %v", err)
+	}
+
+	tagged, err := s.PickTag(userID, "rust")
+	if err != nil {
+		t.Fatalf("PickTag: %v", err)
+	}
+
+	if len(tagged) != 1 {
+		t.Fatalf("PickTag after Update: got %d pages, want 1", len(tagged))
+	}
+	comparePage(t, tagged[0], &want)
+}
+
+func testMarkReadAndRestore(t *testing.T, s storage.Storage) {
+	page := newPage(userID, "https://example.com/a", "go")
+	save(t, s, page)
+
+	if err := s.MarkRead(newPage(userID, page.URL)); err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+
+	if !isExists(t, s, page) {
+		t.Error("IsExists: read page doesn't exist")
+	}
+
+	if _, err := s.PickRandom(userID); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("PickRandom after MarkRead: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+
+	if _, err := s.PickTagRandom(userID, "go"); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("PickTagRandom after MarkRead: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+
+	if err := s.Restore(newPage(userID, page.URL)); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	got, err := s.PickRandom(userID)
+	if err != nil {
+		t.Fatalf("PickRandom after Restore: %v", err)
+	}
+
+	comparePage(t, got, page)
+
+	if got.ReadAt.Valid {
+		t.Error("PickRandom after Restore: page is still read")
+	}
+}
+
+func testHistory(t *testing.T, s storage.Storage) {
+	pages := []*storage.Page{
+		newPage(userID, "https://example.com/a"),
+		newPage(userID, "https://example.com/b"),
+		newPage(userID, "https://example.com/c"),
+	}
+
+	for _, page := range pages {
+		save(t, s, page)
+	}
+	save(t, s, newPage(userID, "https://example.com/unread"))
+
+	for _, page := range pages {
+		if err := s.MarkRead(page); err != nil {
+			t.Fatalf("MarkRead: %v", err)
+		}
+	}
+
+	got, err := s.History(userID, 2)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("History: got %d pages, want 2", len(got))
+	}
+
+	for _, page := range got {
+		if !page.ReadAt.Valid {
+			t.Errorf("History: page %s is not read", page.URL)
+		}
+		if page.URL == "https://example.com/unread" {
+			t.Error("History: got an unread page")
+		}
+	}
+
+	if got[0].ReadAt.Time.Before(got[1].ReadAt.Time) {
+		t.Error("History: pages are not ordered by read time, most recent first")
+	}
+
+	if _, err := s.History(otherUserID, 10); !errors.Is(err, storage.ErrNoSavedPages) {
+		t.Errorf("History of another user: got error %v, want %v", err, storage.ErrNoSavedPages)
+	}
+}
+
+func testSetMeta(t *testing.T, s storage.Storage) {
+	page := newPage(userID, "https://example.com/a", "go")
+	save(t, s, page)
+
+	page.Meta = storage.Metadata{
+		Title:        "The Go Programming Language",
+		Description:  "Go is an open source programming language",
+		SiteName:     "Example",
+		CanonicalURL: "https://example.com/",
+	}
+
+	if err := s.SetMeta(page, page.Meta); err != nil {
+		t.Fatalf("SetMeta: %v", err)
+	}
+
+	got, err := s.PickRandom(userID)
+	if err != nil {
+		t.Fatalf("PickRandom: %v", err)
+	}
+
+	comparePage(t, got, page)
+
+	// The title is searched along with the description and the tags
+	found, err := s.Search(userID, "programming", 0, 10)
+	if err != nil {
+		t.Fatalf("Search by title: %v", err)
+	}
+
+	if len(found) != 1 {
+		t.Fatalf("Search by title: got %d pages, want 1", len(found))
+	}
+
+	comparePage(t, found[0], page)
+}
+
+// testCanonicalURL checks that pages are stored under their canonical URL and found by any
+// of the links leading to it
+func testCanonicalURL(t *testing.T, s storage.Storage) {
+	save(t, s, newPage(userID, "HTTPS://Example.com/a/?utm_source=x#top", "go"))
+
+	for _, url := range []string{"https://example.com/a", "example.com/a", "https://example.com/a?fbclid=1"} {
+		if !isExists(t, s, newPage(userID, url)) {
+			t.Errorf("IsExists %s: page saved as another link to it doesn't exist", url)
+		}
+	}
+
+	got, err := s.PickRandom(userID)
+	if err != nil {
+		t.Fatalf("PickRandom: %v", err)
+	}
+
+	if got.URL != "https://example.com/a" {
+		t.Errorf("PickRandom: got URL %q, want the canonical one", got.URL)
+	}
+
+	if err := s.MarkRead(newPage(userID, "example.com/a/")); err != nil {
+		t.Fatalf("MarkRead by another link: %v", err)
+	}
+
+	if err := s.Remove(newPage(userID, "https://example.com/a#bottom")); err != nil {
+		t.Fatalf("Remove by another link: %v", err)
+	}
+
+	if isExists(t, s, newPage(userID, "https://example.com/a")) {
+		t.Error("IsExists: removed page exists")
+	}
+}
+
+// newPage creates an unread page of the user with the given tags
+func newPage(userID int, url string, tags ...string) *storage.Page {
+	return &storage.Page{
+		URL:      url,
+		UserID:   userID,
+		UserName: "user",
+		Tags:     tags,
+	}
+}
+
+func save(t *testing.T, s storage.Storage, p *storage.Page) {
+	t.Helper()
+
+	if err := s.Save(p); err != nil {
+		t.Fatalf("Save %s: %v", p.URL, err)
+	}
+}
+
+func isExists(t *testing.T, s storage.Storage, p *storage.Page) bool {
+	t.Helper()
+
+	exists, err := s.IsExists(p)
+	if err != nil {
+		t.Fatalf("IsExists %s: %v", p.URL, err)
+	}
+
+	return exists
+}
+
+// comparePage checks the fields every backend has to return as saved, the ID included once it is assigned
+func comparePage(t *testing.T, got, want *storage.Page) {
+	t.Helper()
+
+	if (want.ID != 0 && got.ID != want.ID) || got.URL != want.URL || got.UserID != want.UserID || got.UserName != want.UserName ||
+		!slices.Equal(got.Tags, storage.NormalizeTags(want.Tags)) || got.Description != want.Description ||
+		got.Meta != want.Meta {
+		t.Errorf("got page %+v, want %+v", got, want)
+	}
+}